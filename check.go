@@ -0,0 +1,47 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+
+	"github.com/aerth/markdownd/handler"
+)
+
+// check reports broken links in a directory or archive, and exits 1 if there are any
+func check(args []string) {
+	if len(args) > 1 {
+		flag.Usage()
+		os.Exit(111)
+	}
+	dir := "."
+	if len(args) == 1 {
+		dir = args[0]
+	}
+
+	var files fs.FS
+	if handler.IsArchive(dir) {
+		files, _ = openArchive(dir)
+	} else {
+		files = handler.DirFS(prepareDirectory(dir))
+	}
+
+	// rendered like serve would
+	opts := markdownOptions()
+	opts.Root = files
+
+	broken, err := handler.Check(opts)
+	for _, b := range broken {
+		fmt.Println(b)
+	}
+	if err != nil {
+		println(err.Error())
+		os.Exit(111)
+	}
+	if len(broken) > 0 {
+		fmt.Printf("%d broken links\n", len(broken))
+		os.Exit(1)
+	}
+	fmt.Println("no broken links")
+}
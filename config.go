@@ -0,0 +1,62 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+
+	"gopkg.in/yaml.v2"
+)
+
+// loadConfig sets flags from a yaml file of flag names and values, like
+//
+//	index: README.md
+//	toc: true
+//	extensions: commonmark,tables
+//	diagram:
+//	  - dot=dot -Tsvg
+//	site:
+//	  Version: 1.2
+//
+// flags given on the command line win over the file
+func loadConfig(fs *flag.FlagSet, name string) error {
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		return err
+	}
+	var config map[string]interface{}
+	if err := yaml.Unmarshal(b, &config); err != nil {
+		return fmt.Errorf("%s: %v", name, err)
+	}
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	for key, value := range config {
+		if fs.Lookup(key) == nil || key == "config" {
+			return fmt.Errorf("%s: unknown flag %q", name, key)
+		}
+		if set[key] {
+			continue
+		}
+		// lists are repeated flags, and maps are repeated name=value flags
+		var values []interface{}
+		switch v := value.(type) {
+		case []interface{}:
+			values = v
+		case map[interface{}]interface{}:
+			for k, x := range v {
+				values = append(values, fmt.Sprintf("%v=%v", k, x))
+			}
+		default:
+			values = []interface{}{value}
+		}
+		for _, v := range values {
+			if v == nil {
+				v = ""
+			}
+			if err := fs.Set(key, fmt.Sprint(v)); err != nil {
+				return fmt.Errorf("%s: %s: %v", name, key, err)
+			}
+		}
+	}
+	return nil
+}
@@ -1,20 +1,21 @@
 module github.com/aerth/markdownd
 
-go 1.13
+go 1.16
 
 require (
 	github.com/kr/pretty v0.3.0 // indirect
-	github.com/microcosm-cc/bluemonday v1.0.15 // indirect
+	github.com/kyokomi/emoji/v2 v2.2.13
+	github.com/microcosm-cc/bluemonday v1.0.15
 	github.com/russross/blackfriday v1.6.0
 	github.com/sergi/go-diff v1.2.0 // indirect
-	github.com/shurcooL/github_flavored_markdown v0.0.0-20210228213109-c3a9aa474629
 	github.com/shurcooL/go v0.0.0-20200502201357-93f07166e636 // indirect
 	github.com/shurcooL/go-goon v0.0.0-20210110234559-7585751d9a17 // indirect
-	github.com/shurcooL/highlight_diff v0.0.0-20181222201841-111da2e7d480 // indirect
-	github.com/shurcooL/highlight_go v0.0.0-20191220051317-782971ddf21b // indirect
-	github.com/shurcooL/octicon v0.0.0-20191102190552-cbb32d6a785c // indirect
-	github.com/shurcooL/sanitized_anchor_name v1.0.0 // indirect
-	github.com/sourcegraph/annotate v0.0.0-20160123013949-f4cad6c6324d // indirect
+	github.com/shurcooL/highlight_diff v0.0.0-20181222201841-111da2e7d480
+	github.com/shurcooL/highlight_go v0.0.0-20191220051317-782971ddf21b
+	github.com/shurcooL/sanitized_anchor_name v1.0.0
+	github.com/sourcegraph/annotate v0.0.0-20160123013949-f4cad6c6324d
 	github.com/sourcegraph/syntaxhighlight v0.0.0-20170531221838-bd320f5d308e
-	golang.org/x/net v0.0.0-20210805182204-aaa1db679c0d // indirect
+	golang.org/x/crypto v0.0.0-20210817164053-32db794688a5
+	golang.org/x/net v0.0.0-20210805182204-aaa1db679c0d
+	gopkg.in/yaml.v2 v2.4.0
 )
@@ -0,0 +1,70 @@
+package handler
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// a blockquote starting with a line like "> [!NOTE]" is a github alert
+var (
+	alertRegexp     = regexp.MustCompile(`^ {0,3}> ?\[!(?i)(note|tip|important|warning|caution)\][ \t]*\r?\n?$`)
+	quoteLineRegexp = regexp.MustCompile(`^ {0,3}> ?`)
+)
+
+// octicons of each kind of alert, see theme/header.html
+var alertIcons = map[string]string{
+	"note":      "info",
+	"tip":       "light-bulb",
+	"important": "megaphone",
+	"warning":   "alert",
+	"caution":   "stop",
+}
+
+// alerts replaces github alerts outside of fenced code with their content,
+// between placeholders for a titled div like github renders, sanitized by s
+func alerts(src []byte, ph *placeholders, s *Sanitizer) []byte {
+	lines := bytes.SplitAfter(src, []byte("\n"))
+	var out bytes.Buffer
+	var fence []byte
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		trimmed := bytes.TrimLeft(line, " ")
+		if fence != nil {
+			out.Write(line)
+			if closesFence(trimmed, fence) {
+				fence = nil
+			}
+			continue
+		}
+		if fence = opensFence(trimmed); fence != nil && len(line)-len(trimmed) < 4 {
+			out.Write(line)
+			continue
+		}
+		fence = nil
+		m := alertRegexp.FindSubmatch(line)
+		if m == nil || i+1 == len(lines) || !quoteLineRegexp.Match(lines[i+1]) {
+			out.Write(line)
+			continue
+		}
+
+		// the rest of the blockquote, unquoted
+		var body bytes.Buffer
+		for i+1 < len(lines) && quoteLineRegexp.Match(lines[i+1]) {
+			i++
+			body.Write(lines[i][len(quoteLineRegexp.Find(lines[i])):])
+		}
+		kind := strings.ToLower(string(m[1]))
+		out.WriteString("\n")
+		out.Write(ph.addBlock(s.sanitize([]byte(fmt.Sprintf(`<div class="markdown-alert markdown-alert-%s">`+
+			`<p class="markdown-alert-title"><span class="octicon octicon-%s"></span> %s</p>`,
+			kind, alertIcons[kind], strings.Title(kind))))))
+		out.WriteString("\n\n")
+		out.Write(body.Bytes())
+		out.WriteString("\n\n")
+		out.Write(ph.addBlock([]byte("</div>")))
+		out.WriteString("\n\n")
+	}
+	return out.Bytes()
+}
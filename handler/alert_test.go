@@ -0,0 +1,65 @@
+package handler
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAlerts(t *testing.T) {
+	h := newHandler(t, Options{Alerts: true}).(*handler)
+	for _, tc := range []struct {
+		src     string
+		want    []string
+		notwant []string
+	}{
+		{"> [!NOTE]\n> Read *this*.\n", []string{
+			`<div class="markdown-alert markdown-alert-note"><p class="markdown-alert-title"><span class="octicon octicon-info"></span> Note</p>`,
+			"<p>Read <em>this</em>.</p>\n\n</div>",
+		}, []string{"<blockquote>", "[!NOTE]"}},
+		{"> [!caution]\n> ```go\n> x := 1\n> ```\n\nafter\n", []string{"markdown-alert-caution", `<div class="highlight highlight-go">`, "</div>\n\n<p>after</p>"}, nil},
+		{"> [!NOTE]\n\nalone\n", []string{"<blockquote>", "[!NOTE]"}, []string{"markdown-alert"}},
+		{"> [!NOPE]\n> text\n", []string{"<blockquote>", "[!NOPE]"}, []string{"markdown-alert"}},
+		{"```\n> [!NOTE]\n> code\n```\n", []string{"&gt; [!NOTE]"}, []string{"markdown-alert"}},
+	} {
+		out := string(h.markdown2html([]byte(tc.src)))
+		for _, want := range tc.want {
+			if !strings.Contains(out, want) {
+				t.Errorf("%q: expected %q in %q", tc.src, want, out)
+			}
+		}
+		for _, notwant := range tc.notwant {
+			if strings.Contains(out, notwant) {
+				t.Errorf("%q: did not expect %q in %q", tc.src, notwant, out)
+			}
+		}
+	}
+}
+
+func TestMarkdownExtras(t *testing.T) {
+	src := []byte("> [!TIP]\n> Tip\n\nText[^1] :tada: `:tada:` :nope:\n\nTerm\n: Definition\n\n[^1]: The note.\n")
+	for _, isPlain := range []bool{false, true} {
+		h := newHandler(t, Options{Plain: isPlain, Alerts: true, Footnotes: true, DefinitionLists: true, Emoji: true}).(*handler)
+		out := string(h.markdown2html(src))
+		for _, want := range []string{
+			`markdown-alert-tip`,
+			`Text<sup id="fnref:1"><a href="#fn:1" rel="nofollow">1</a></sup>`,
+			`<li id="fn:1">The note.`,
+			`<a class="footnote-return" href="#fnref:1" rel="nofollow">↩</a>`,
+			"🎉 <code>:tada:</code> :nope:",
+			"<dl>\n<dt>Term</dt>\n<dd>Definition</dd>\n</dl>",
+		} {
+			if !strings.Contains(out, want) {
+				t.Errorf("plain=%v: expected %q in %q", isPlain, want, out)
+			}
+		}
+
+		// each is off by default
+		h = newHandler(t, Options{Plain: isPlain}).(*handler)
+		out = string(h.markdown2html(src))
+		for _, notwant := range []string{"markdown-alert", "<sup", "🎉", "<dl>"} {
+			if strings.Contains(out, notwant) {
+				t.Errorf("plain=%v: did not expect %q in %q", isPlain, notwant, out)
+			}
+		}
+	}
+}
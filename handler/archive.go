@@ -0,0 +1,373 @@
+package handler
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// IsArchive returns true if name looks like a zip, tar, or tar.gz archive
+func IsArchive(name string) bool {
+	name = strings.ToLower(name)
+	for _, ext := range []string{".zip", ".tar", ".tar.gz", ".tgz"} {
+		if strings.HasSuffix(name, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// limits of archives, so a zip or gzip bomb can not fill memory
+const (
+	maxArchiveSize  = 1 << 28 // total uncompressed size of the files in an archive
+	maxArchiveEntry = 1 << 26 // uncompressed size of one file in an archive
+	maxArchiveFiles = 100000  // files and directories in an archive
+)
+
+var errArchiveTooLarge = errors.New("archive too large")
+
+// ArchiveFS returns a filesystem for the contents of the archive r,
+// which is a zip, tar, or tar.gz file depending on the extension of name.
+// zip files are read in place, tar files are read into memory.
+// archives larger than the limits above, once uncompressed, are refused.
+func ArchiveFS(name string, r io.ReaderAt, size int64) (fs.FS, error) {
+	lower := strings.ToLower(name)
+	if strings.HasSuffix(lower, ".zip") {
+		zr, err := zip.NewReader(r, size)
+		if err != nil {
+			return nil, err
+		}
+		if len(zr.File) > maxArchiveFiles {
+			return nil, errArchiveTooLarge
+		}
+		var total uint64
+		for _, f := range zr.File {
+			// the zip reader fails reading past these sizes
+			total += f.UncompressedSize64
+			if f.UncompressedSize64 > maxArchiveEntry || total > maxArchiveSize {
+				return nil, errArchiveTooLarge
+			}
+		}
+		return zr, nil
+	}
+	var tr io.Reader = io.NewSectionReader(r, 0, size)
+	if strings.HasSuffix(lower, ".gz") || strings.HasSuffix(lower, ".tgz") {
+		gz, err := gzip.NewReader(tr)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		tr = gz
+	}
+	return readTar(tar.NewReader(tr))
+}
+
+// readTar reads every regular file of a tar archive into memory.
+// symlinks and hard links are kept as symlinks, so they are refused.
+func readTar(tr *tar.Reader) (fs.FS, error) {
+	m := memFS{".": &memFile{name: ".", mode: fs.ModeDir | 0755}}
+	var total int64
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return m, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		name := path.Clean(strings.TrimPrefix(hdr.Name, "/"))
+		if !fs.ValidPath(name) || name == "." {
+			continue
+		}
+		if len(m) >= maxArchiveFiles {
+			return nil, errArchiveTooLarge
+		}
+		f := &memFile{name: name, modtime: hdr.ModTime, mode: fs.FileMode(hdr.Mode).Perm()}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			f.mode |= fs.ModeDir
+		case tar.TypeSymlink, tar.TypeLink:
+			f.mode |= fs.ModeSymlink
+		case tar.TypeReg, tar.TypeRegA:
+			// the tar reader reads no more than the size in the header
+			total += hdr.Size
+			if hdr.Size > maxArchiveEntry || total > maxArchiveSize {
+				return nil, errArchiveTooLarge
+			}
+			if f.data, err = ioutil.ReadAll(tr); err != nil {
+				return nil, err
+			}
+		default:
+			continue
+		}
+		m.add(f)
+	}
+}
+
+// memFS is a read only filesystem in memory
+type memFS map[string]*memFile
+
+type memFile struct {
+	name    string
+	data    []byte
+	mode    fs.FileMode
+	modtime time.Time
+}
+
+// add f, and the directories leading to it
+func (m memFS) add(f *memFile) {
+	if old, ok := m[f.name]; ok && old.mode.IsDir() && f.mode.IsDir() {
+		return
+	}
+	m[f.name] = f
+	for dir := path.Dir(f.name); dir != "."; dir = path.Dir(dir) {
+		if _, ok := m[dir]; ok {
+			break
+		}
+		m[dir] = &memFile{name: dir, mode: fs.ModeDir | 0755, modtime: f.modtime}
+	}
+}
+
+func (m memFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	f, ok := m[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	if f.mode.IsDir() {
+		return &memDir{memFile: f, entries: m.entries(name)}, nil
+	}
+	return &openMemFile{memFile: f, Reader: bytes.NewReader(f.data)}, nil
+}
+
+// Lstat returns symlinks as they are in the archive
+func (m memFS) Lstat(name string) (fs.FileInfo, error) {
+	f, ok := m[name]
+	if !ok || !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "lstat", Path: name, Err: fs.ErrNotExist}
+	}
+	return f, nil
+}
+
+// entries of directory dir, sorted by name
+func (m memFS) entries(dir string) []fs.DirEntry {
+	var list []fs.DirEntry
+	for name, f := range m {
+		if name != "." && path.Dir(name) == dir {
+			list = append(list, f)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name() < list[j].Name() })
+	return list
+}
+
+// memFile is its own fs.FileInfo and fs.DirEntry
+func (f *memFile) Name() string               { return path.Base(f.name) }
+func (f *memFile) Size() int64                { return int64(len(f.data)) }
+func (f *memFile) Mode() fs.FileMode          { return f.mode }
+func (f *memFile) ModTime() time.Time         { return f.modtime }
+func (f *memFile) IsDir() bool                { return f.mode.IsDir() }
+func (f *memFile) Sys() interface{}           { return nil }
+func (f *memFile) Type() fs.FileMode          { return f.mode.Type() }
+func (f *memFile) Info() (fs.FileInfo, error) { return f, nil }
+
+type openMemFile struct {
+	*memFile
+	*bytes.Reader
+}
+
+func (f *openMemFile) Stat() (fs.FileInfo, error) { return f.memFile, nil }
+func (f *openMemFile) Close() error               { return nil }
+
+type memDir struct {
+	*memFile
+	entries []fs.DirEntry
+}
+
+func (d *memDir) Stat() (fs.FileInfo, error) { return d.memFile, nil }
+func (d *memDir) Close() error               { return nil }
+func (d *memDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.name, Err: errors.New("is a directory")}
+}
+
+func (d *memDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if n <= 0 {
+		list := d.entries
+		d.entries = nil
+		return list, nil
+	}
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(d.entries) {
+		n = len(d.entries)
+	}
+	list := d.entries[:n]
+	d.entries = d.entries[n:]
+	return list, nil
+}
+
+// archiveFS serves the contents of archives found in root as directories,
+// so "docs.zip/index.md" is the file index.md inside docs.zip
+type archiveFS struct {
+	root fs.FS
+
+	mu    sync.Mutex
+	cache map[string]*cachedArchive
+}
+
+type cachedArchive struct {
+	modtime time.Time
+	fsys    fs.FS
+}
+
+// maximum number of archives kept open
+const archiveCacheSize = 16
+
+func newArchiveFS(root fs.FS) *archiveFS {
+	return &archiveFS{root: root, cache: make(map[string]*cachedArchive)}
+}
+
+// split name into an archive in root, and a name inside of it
+func (a *archiveFS) split(name string) (archive, inner string, ok bool) {
+	elems := strings.Split(name, "/")
+	for i, elem := range elems {
+		if IsArchive(elem) {
+			archive = path.Join(elems[:i+1]...)
+			if fi, err := fs.Stat(a.root, archive); err != nil || !fi.Mode().IsRegular() {
+				return "", "", false
+			}
+			return archive, path.Join(append([]string{"."}, elems[i+1:]...)...), true
+		}
+	}
+	return "", "", false
+}
+
+// open the archive named name in root, or reuse it.
+// archives are read in place if root's files can be, and kept until modified.
+func (a *archiveFS) open(name string) (fs.FS, error) {
+	fi, err := fs.Stat(a.root, name)
+	if err != nil {
+		return nil, err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if c, ok := a.cache[name]; ok && c.modtime.Equal(fi.ModTime()) {
+		return c.fsys, nil
+	}
+
+	f, err := a.root.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	// an open file is closed by the garbage collector once it leaves the cache,
+	// not here, where a request may still be reading from it
+	r, ok := f.(io.ReaderAt)
+	size := fi.Size()
+	if !ok {
+		defer f.Close()
+		b, err := ioutil.ReadAll(io.LimitReader(f, maxArchiveSize+1))
+		if err != nil {
+			return nil, err
+		}
+		if len(b) > maxArchiveSize {
+			return nil, errArchiveTooLarge
+		}
+		r, size = bytes.NewReader(b), int64(len(b))
+	}
+	fsys, err := ArchiveFS(name, r, size)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if len(a.cache) >= archiveCacheSize {
+		for k := range a.cache {
+			delete(a.cache, k)
+			break
+		}
+	}
+	a.cache[name] = &cachedArchive{modtime: fi.ModTime(), fsys: fsys}
+	return fsys, nil
+}
+
+// archive returns the archive filesystem containing name, if any
+func (a *archiveFS) archive(name string) (fsys fs.FS, inner string, ok bool, err error) {
+	archive, inner, ok := a.split(name)
+	if !ok {
+		return nil, "", false, nil
+	}
+	// the archive file itself is served as is
+	if inner == "." && archive == name {
+		return nil, "", false, nil
+	}
+	fsys, err = a.open(archive)
+	return fsys, inner, true, err
+}
+
+func (a *archiveFS) Open(name string) (fs.File, error) {
+	fsys, inner, ok, err := a.archive(name)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return a.root.Open(name)
+	}
+	return fsys.Open(inner)
+}
+
+// ReadDir lists an archive like a directory
+func (a *archiveFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if archive, _, ok := a.split(name); ok && archive == name {
+		fsys, err := a.open(archive)
+		if err != nil {
+			return nil, err
+		}
+		return fs.ReadDir(fsys, ".")
+	}
+	fsys, inner, ok, err := a.archive(name)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return fs.ReadDir(a.root, name)
+	}
+	return fs.ReadDir(fsys, inner)
+}
+
+func (a *archiveFS) Lstat(name string) (fs.FileInfo, error) {
+	fsys, inner, ok, err := a.archive(name)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		fsys, inner = a.root, name
+	}
+	if l, ok := fsys.(lstatFS); ok {
+		return l.Lstat(inner)
+	}
+	return fs.Stat(fsys, inner)
+}
+
+// WriteFile writes to root, but never inside of archives
+func (a *archiveFS) WriteFile(name string, data []byte) error {
+	if _, _, ok := a.split(name); ok {
+		return &fs.PathError{Op: "write", Path: name, Err: fs.ErrPermission}
+	}
+	w, ok := a.root.(WriteFS)
+	if !ok {
+		return &fs.PathError{Op: "write", Path: name, Err: fs.ErrPermission}
+	}
+	return w.WriteFile(name, data)
+}
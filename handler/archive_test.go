@@ -0,0 +1,154 @@
+package handler
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func testZip(t *testing.T) []byte {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, body := range map[string]string{
+		"index.md":     "# zipped\n",
+		"sub/page.md":  "zipped sub page\n",
+		"sub/data.txt": "plain text\n",
+	} {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(body))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func testTarGz(t *testing.T) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	add := func(hdr *tar.Header, body string) {
+		hdr.Size = int64(len(body))
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write([]byte(body))
+	}
+	add(&tar.Header{Name: "docs/index.md", Mode: 0644, Typeflag: tar.TypeReg}, "# tarred\n")
+	add(&tar.Header{Name: "docs/passwd.md", Linkname: "/etc/passwd", Typeflag: tar.TypeSymlink}, "")
+	tw.Close()
+	gz.Close()
+	return buf.Bytes()
+}
+
+func TestServeArchives(t *testing.T) {
+	zipped := testZip(t)
+	tarred := testTarGz(t)
+
+	zfs, err := ArchiveFS("docs.zip", bytes.NewReader(zipped), int64(len(zipped)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tfs, err := ArchiveFS("docs.tar.gz", bytes.NewReader(tarred), int64(len(tarred)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		h          http.Handler
+		path, want string
+		code       int
+	}{
+		{newHandler(t, Options{Root: zfs}), "/", "zipped", 200},
+		{newHandler(t, Options{Root: zfs}), "/sub/page.html", "zipped sub page", 200},
+		{newHandler(t, Options{Root: zfs}), "/sub/data.txt", "plain text", 200},
+		{newHandler(t, Options{Root: tfs}), "/docs/", "tarred", 200},
+		{newHandler(t, Options{Root: tfs}), "/docs/passwd.md", "404", 404},
+		{newHandler(t, Options{Root: tfs, GenerateIndex: true}), "/docs/", "index.md", 200},
+	} {
+		req, _ := http.NewRequest("GET", tc.path, nil)
+		w := httptest.NewRecorder()
+		tc.h.ServeHTTP(w, req)
+		if w.Code != tc.code || !strings.Contains(w.Body.String(), tc.want) {
+			t.Errorf("%s: expected %d %q, got %d %q", tc.path, tc.code, tc.want, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestBrowseArchives(t *testing.T) {
+	root := fstest.MapFS{
+		"index.md":         {Data: []byte("# top\n")},
+		"bundles/v1.2.zip": {Data: testZip(t)},
+	}
+	for _, tc := range []struct {
+		archives   bool
+		path, want string
+		code       int
+	}{
+		{true, "/bundles/v1.2.zip/", "zipped", 200},
+		{true, "/bundles/v1.2.zip/sub/page.md", "zipped sub page", 200},
+		{true, "/bundles/v1.2.zip", "PK", 200}, // the zip file itself
+		{false, "/bundles/v1.2.zip/", "404", 404},
+	} {
+		req, _ := http.NewRequest("GET", tc.path, nil)
+		w := httptest.NewRecorder()
+		newHandler(t, Options{Root: root, Archives: tc.archives}).ServeHTTP(w, req)
+		if w.Code != tc.code || !strings.Contains(w.Body.String(), tc.want) {
+			t.Errorf("%s (archives=%v): expected %d %q, got %d %q", tc.path, tc.archives, tc.code, tc.want, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestArchiveLimits(t *testing.T) {
+	// a header claiming more than the limit is refused before reading it
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	if err := tw.WriteHeader(&tar.Header{Name: "bomb.md", Mode: 0644, Typeflag: tar.TypeReg, Size: maxArchiveEntry + 1}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ArchiveFS("bomb.tar", bytes.NewReader(buf.Bytes()), int64(buf.Len())); err != errArchiveTooLarge {
+		t.Errorf("expected %v, got %v", errArchiveTooLarge, err)
+	}
+
+	// so are many small files
+	buf.Reset()
+	tw = tar.NewWriter(&buf)
+	for i := 0; i <= maxArchiveFiles; i++ {
+		tw.WriteHeader(&tar.Header{Name: fmt.Sprintf("d%d/", i), Mode: 0755, Typeflag: tar.TypeDir})
+	}
+	tw.Close()
+	if _, err := ArchiveFS("many.tar", bytes.NewReader(buf.Bytes()), int64(buf.Len())); err != errArchiveTooLarge {
+		t.Errorf("expected %v, got %v", errArchiveTooLarge, err)
+	}
+
+	// and a zip with an entry larger than the limit
+	buf.Reset()
+	zw := zip.NewWriter(&buf)
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: "bomb.md", Method: zip.Deflate})
+	if err != nil {
+		t.Fatal(err)
+	}
+	zeros := make([]byte, 1<<20)
+	for i := 0; i <= maxArchiveEntry>>20; i++ {
+		w.Write(zeros)
+	}
+	zw.Close()
+	if _, err := ArchiveFS("bomb.zip", bytes.NewReader(buf.Bytes()), int64(buf.Len())); err != errArchiveTooLarge {
+		t.Errorf("expected %v, got %v", errArchiveTooLarge, err)
+	}
+	root := fstest.MapFS{"bomb.zip": {Data: buf.Bytes()}}
+	w2 := httptest.NewRecorder()
+	newHandler(t, Options{Root: root, Archives: true}).ServeHTTP(w2, httptest.NewRequest("GET", "/bomb.zip/bomb.md", nil))
+	if w2.Code != 404 {
+		t.Errorf("expected 404 for a zip bomb, got %d", w2.Code)
+	}
+}
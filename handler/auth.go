@@ -0,0 +1,117 @@
+package handler
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Authenticator checks who makes a request, for Options.Auth
+type Authenticator interface {
+	// Authenticate returns the name of the user making request r, and false if it is not authenticated
+	Authenticate(r *http.Request) (user string, ok bool)
+}
+
+// identifier is an Authenticator that knows the git author of its users
+type identifier interface {
+	Identity(user string) string
+}
+
+// Users authenticates http basic auth requests against bcrypt password hashes
+type Users struct {
+	users map[string]*user
+	dummy []byte // hash checked for unknown users, so they take as long as known ones
+
+	mu   sync.Mutex
+	seen map[[sha256.Size]byte]bool // verified credentials, bcrypt is slow on purpose
+}
+
+type user struct {
+	hash     []byte
+	identity string // git author, like "Name <email>"
+}
+
+// LoadUsers reads a htpasswd file with bcrypt hashes ('htpasswd -B'), one user per line.
+// an optional third field is the git identity of the user:
+//
+//	alice:$2y$10$...:Alice Liddell <alice@example.com>
+func LoadUsers(name string) (*Users, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	u := &Users{users: make(map[string]*user), seen: make(map[[sha256.Size]byte]bool)}
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.SplitN(line, ":", 3)
+		if len(fields) < 2 || fields[0] == "" {
+			return nil, fmt.Errorf("%s:%d: expected 'name:hash'", name, n)
+		}
+		if _, err := bcrypt.Cost([]byte(fields[1])); err != nil {
+			return nil, fmt.Errorf("%s:%d: not a bcrypt hash, use 'htpasswd -B'", name, n)
+		}
+		identity := fields[0] + " <>"
+		if len(fields) == 3 && strings.TrimSpace(fields[2]) != "" {
+			identity = strings.TrimSpace(fields[2])
+		}
+		u.users[fields[0]] = &user{hash: []byte(fields[1]), identity: identity}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(u.users) == 0 {
+		return nil, fmt.Errorf("%s: no users", name)
+	}
+	u.dummy, err = bcrypt.GenerateFromPassword([]byte("markdownd"), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// Authenticate checks http basic auth
+func (u *Users) Authenticate(r *http.Request) (string, bool) {
+	name, password, ok := r.BasicAuth()
+	if !ok {
+		return "", false
+	}
+	key := sha256.Sum256([]byte(name + ":" + password))
+	u.mu.Lock()
+	seen := u.seen[key]
+	u.mu.Unlock()
+	if seen {
+		return name, true
+	}
+
+	usr, ok := u.users[name]
+	if !ok {
+		bcrypt.CompareHashAndPassword(u.dummy, []byte(password))
+		return "", false
+	}
+	if bcrypt.CompareHashAndPassword(usr.hash, []byte(password)) != nil {
+		return "", false
+	}
+	u.mu.Lock()
+	u.seen[key] = true
+	u.mu.Unlock()
+	return name, true
+}
+
+// Identity returns the git author of a user, like "Name <email>"
+func (u *Users) Identity(name string) string {
+	if usr, ok := u.users[name]; ok {
+		return usr.identity
+	}
+	return name + " <>"
+}
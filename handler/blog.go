@@ -0,0 +1,554 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+// url paths of the blog feeds, after the base path
+const (
+	atomPath = "/feed.atom"
+	rssPath  = "/feed.rss"
+	jsonPath = "/feed.json"
+)
+
+// feedSize is how many of the newest posts are in feeds
+const feedSize = 20
+
+// defaultPageSize is how many posts are on a listing page
+const defaultPageSize = 10
+
+// dated file names of posts, like 2006-01-02-hello-world.md
+var postNameRegexp = regexp.MustCompile(`^(\d{4}-\d{2}-\d{2})-(.+)\.md$`)
+
+// Blog lists the dated posts of a directory, newest first, with feeds
+type Blog struct {
+	Dir      string // directory of posts in Root, "." for all of it
+	Title    string // title of listing pages and feeds, defaults to the title in the front matter of the Index page of Dir
+	URL      string // scheme and host of the site, like "https://example.com", for feeds, defaults to the r.URL a trusted proxy sets
+	PageSize int    // posts per listing page, defaults to 10
+}
+
+// Post is a markdown page with a date in its front matter, or its file name
+type Post struct {
+	Name    string // file name in Root
+	URL     string // link with the base path
+	Title   string // front matter title, first heading, or file name
+	Date    time.Time
+	Summary string // front matter summary or description
+	Tags    []string
+}
+
+// blogCache keeps the posts until a file in the blog changes
+type blogCache struct {
+	mu    sync.Mutex
+	state map[string]fileState
+	posts []Post
+}
+
+// posts returns the published posts of the blog, newest first.
+// drafts, and posts dated in the future, are left out.
+func (h *handler) posts() []Post {
+	state := make(map[string]fileState)
+	fs.WalkDir(h.Root, h.Blog.Dir, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if name != "." && !h.visible(name) {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if d.Type().IsRegular() && strings.HasSuffix(name, ".md") {
+			if fi, err := d.Info(); err == nil {
+				state[name] = fileState{modtime: fi.ModTime().UnixNano(), size: fi.Size()}
+			}
+		}
+		return nil
+	})
+
+	c := h.blogCache
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !sameState(c.state, state) {
+		c.posts = nil
+		for name := range state {
+			if post, ok := h.post(name); ok {
+				c.posts = append(c.posts, post)
+			}
+		}
+		sort.Slice(c.posts, func(i, j int) bool {
+			if !c.posts[i].Date.Equal(c.posts[j].Date) {
+				return c.posts[i].Date.After(c.posts[j].Date)
+			}
+			return c.posts[i].Name < c.posts[j].Name
+		})
+		c.state = state
+	}
+
+	// published by now
+	now := time.Now()
+	var posts []Post
+	for _, post := range c.posts {
+		if !post.Date.After(now) {
+			posts = append(posts, post)
+		}
+	}
+	return posts
+}
+
+func sameState(a, b map[string]fileState) bool {
+	if a == nil || len(a) != len(b) {
+		return false
+	}
+	for name, state := range a {
+		if b[name] != state {
+			return false
+		}
+	}
+	return true
+}
+
+// post reads the post in file name, if it has a date and is not a draft
+func (h *handler) post(name string) (Post, bool) {
+	b, err := fs.ReadFile(h.Root, name)
+	if err != nil {
+		return Post{}, false
+	}
+	meta, src := frontMatter(b)
+	if metaBool(meta, "draft", false) {
+		return Post{}, false
+	}
+	post := Post{Name: name, URL: (&url.URL{Path: h.BasePath + "/" + name}).String(), Tags: metaStrings(meta, "tags")}
+	m := postNameRegexp.FindStringSubmatch(path.Base(name))
+	date, ok := metaTime(meta, "date")
+	if !ok && m != nil {
+		date, err = time.Parse("2006-01-02", m[1])
+		ok = err == nil
+	}
+	if !ok {
+		return Post{}, false
+	}
+	post.Date = date
+
+	post.Title, _ = meta["title"].(string)
+	if post.Title == "" {
+		post.Title = pageTitle(name, src)
+		if post.Title == path.Base(name) && m != nil {
+			post.Title = m[2]
+		}
+	}
+	for _, key := range []string{"summary", "description"} {
+		if s, ok := meta[key].(string); ok && post.Summary == "" {
+			post.Summary = s
+		}
+	}
+	return post, true
+}
+
+// blogPrefix returns the url path of the blog's listing, like "/posts/"
+func (h *handler) blogPrefix() string {
+	if h.Blog.Dir == "." {
+		return "/"
+	}
+	return "/" + strings.Trim(h.Blog.Dir, "/") + "/"
+}
+
+// serveBlog writes listing pages and feeds, and returns false for other paths.
+// listings are at the blog directory, like /posts/ and /posts/page/2/.
+// tags are listed by the taxonomy of the same name, at /tags/go/.
+func (h *handler) serveBlog(w http.ResponseWriter, r *http.Request) bool {
+	switch r.URL.Path {
+	case atomPath, rssPath, jsonPath:
+		h.serveFeed(w, r)
+		return true
+	}
+	prefix := h.blogPrefix()
+	if !strings.HasPrefix(r.URL.Path, prefix) || !strings.HasSuffix(r.URL.Path, "/") {
+		return false
+	}
+	rest := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, prefix), "/"), "/")
+	if rest[0] == "" {
+		rest = nil
+	}
+
+	n := 1
+	if len(rest) == 2 && rest[0] == "page" {
+		var err error
+		if n, err = strconv.Atoi(rest[1]); err != nil || n < 2 {
+			return false
+		}
+		rest = nil
+	}
+	if len(rest) != 0 {
+		return false
+	}
+
+	posts := h.posts()
+	size := h.Blog.PageSize
+	if size <= 0 {
+		size = defaultPageSize
+	}
+	first := (n - 1) * size
+	if first >= len(posts) && n > 1 {
+		http.NotFound(w, r)
+		return true
+	}
+	last := first + size
+	if last > len(posts) {
+		last = len(posts)
+	}
+
+	listing := blogListing{Title: h.blogInfo().Title, Posts: posts[first:last], Base: h.BasePath, Prefix: h.BasePath + prefix,
+		Feeds: h.feedSite(r) != "", termURL: h.termURL}
+	page := listing.Prefix
+	if n == 2 {
+		listing.Newer = page
+	} else if n > 2 {
+		listing.Newer = page + "page/" + strconv.Itoa(n-1) + "/"
+	}
+	if last < len(posts) {
+		listing.Older = page + "page/" + strconv.Itoa(n+1) + "/"
+	}
+
+	var buf bytes.Buffer
+	if err := blogTemplate.Execute(&buf, listing); err != nil {
+		h.Logger.Println("error executing blog template:", err)
+		http.Error(w, "500 internal server error", http.StatusInternalServerError)
+		return true
+	}
+	h.servePage(w, r, "", buf.Bytes())
+	return true
+}
+
+func hasString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// blogListing is the data of the blog template
+type blogListing struct {
+	Title        string
+	Posts        []Post
+	Base, Prefix string // base path, and url path of the blog
+	Newer, Older string // pages of newer and older posts
+	Feeds        bool   // served, with a site url
+
+	termURL func(taxonomy, term string) string
+}
+
+// TagURL returns the listing of a tag, by the tags taxonomy
+func (l blogListing) TagURL(tag string) string {
+	return l.termURL("tags", tag)
+}
+
+var blogTemplate = template.Must(template.New("blog").Parse(`{{with .Title}}<h1>{{.}}</h1>
+{{end}}<ul class="posts">
+{{range .Posts}}<li><a href="{{.URL}}">{{.Title}}</a> <time datetime="{{.Date.Format "2006-01-02"}}">{{.Date.Format "January 2, 2006"}}</time>
+{{with .Summary}}<p>{{.}}</p>
+{{end}}{{with .Tags}}<p class="tags">{{range .}}<a href="{{$.TagURL .}}">#{{.}}</a> {{end}}</p>
+{{end}}</li>
+{{else}}<li>no posts yet</li>
+{{end}}</ul>
+<nav class="pagination">{{with .Newer}}<a rel="prev" href="{{.}}">Newer posts</a>{{end}} {{with .Older}}<a rel="next" href="{{.}}">Older posts</a>{{end}}</nav>
+{{if .Feeds}}<p class="feeds">Feeds: <a href="{{.Base}}/feed.atom">Atom</a> <a href="{{.Base}}/feed.rss">RSS</a> <a href="{{.Base}}/feed.json">JSON</a></p>
+{{end}}`))
+
+// blogInfo describes the blog in feeds
+type blogInfo struct {
+	Title       string
+	Description string
+	Author      string
+}
+
+// blogInfo returns Blog.Title, and the title, description and author
+// in the front matter of the Index page of the blog directory
+func (h *handler) blogInfo() blogInfo {
+	info := blogInfo{Title: h.Blog.Title}
+	if h.Index == "" {
+		return info
+	}
+	b, err := fs.ReadFile(h.Root, path.Join(h.Blog.Dir, h.Index))
+	if err != nil {
+		return info
+	}
+	meta, _ := frontMatter(b)
+	if s, ok := meta["title"].(string); ok && info.Title == "" {
+		info.Title = s
+	}
+	for _, key := range []string{"description", "summary"} {
+		if s, ok := meta[key].(string); ok && info.Description == "" {
+			info.Description = s
+		}
+	}
+	info.Author, _ = meta["author"].(string)
+	return info
+}
+
+// feedPost is a post with absolute urls and its rendered content
+type feedPost struct {
+	Post
+	URL  string
+	HTML string
+}
+
+// feedSite returns the scheme and host of absolute urls in feeds: Blog.URL, or else the host
+// and scheme a trusted proxy set in r.URL, never the Host header of any client. "" without either.
+func (h *handler) feedSite(r *http.Request) string {
+	if h.Blog.URL != "" {
+		return strings.TrimSuffix(h.Blog.URL, "/")
+	}
+	if r.URL.Host == "" {
+		return ""
+	}
+	scheme := r.URL.Scheme
+	if scheme == "" {
+		scheme = "http"
+		if r.TLS != nil {
+			scheme = "https"
+		}
+	}
+	return scheme + "://" + r.URL.Host
+}
+
+// serveFeed writes the newest posts as an atom, rss or json feed
+func (h *handler) serveFeed(w http.ResponseWriter, r *http.Request) {
+	site := h.feedSite(r)
+	if site == "" {
+		h.Logger.Println("error writing feed: no site url, set Blog.URL")
+		http.NotFound(w, r)
+		return
+	}
+	home := site + h.BasePath + h.blogPrefix()
+	self := site + h.BasePath + r.URL.Path
+	info := h.blogInfo()
+	if info.Title == "" {
+		if u, err := url.Parse(site); err == nil && u.Host != "" {
+			info.Title = u.Host
+		} else {
+			info.Title = site
+		}
+	}
+	if info.Description == "" {
+		info.Description = info.Title
+	}
+
+	posts := h.posts()
+	if len(posts) > feedSize {
+		posts = posts[:feedSize]
+	}
+	var items []feedPost
+	for _, post := range posts {
+		item := feedPost{Post: post, URL: site + post.URL}
+		if b, err := fs.ReadFile(h.Root, post.Name); err == nil {
+			item.HTML = string(h.renderFile(post.Name, b).HTML)
+			if base, err := url.Parse(item.URL); err == nil {
+				item.HTML = string(absoluteURLs([]byte(item.HTML), base))
+			}
+		}
+		items = append(items, item)
+	}
+	updated := time.Now()
+	if len(posts) > 0 {
+		updated = posts[0].Date
+	}
+
+	var b []byte
+	var err error
+	switch r.URL.Path {
+	case atomPath:
+		w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+		b, err = atomFeed(info, home, self, updated, items)
+	case rssPath:
+		w.Header().Set("Content-Type", "application/rss+xml; charset=utf-8")
+		b, err = rssFeed(info, home, self, items)
+	default:
+		w.Header().Set("Content-Type", "application/feed+json; charset=utf-8")
+		b, err = jsonFeed(info, home, self, items)
+	}
+	if err != nil {
+		h.Logger.Println("error writing feed:", err)
+		w.Header().Del("Content-Type")
+		http.Error(w, "500 internal server error", http.StatusInternalServerError)
+		return
+	}
+	w.Write(b)
+}
+
+// absoluteURLs resolves the relative links and image sources of html against base,
+// for feed readers showing it on another site
+func absoluteURLs(b []byte, base *url.URL) []byte {
+	var out bytes.Buffer
+	z := html.NewTokenizer(bytes.NewReader(b))
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			return out.Bytes()
+		}
+		raw := append([]byte(nil), z.Raw()...)
+		if tt != html.StartTagToken && tt != html.SelfClosingTagToken {
+			out.Write(raw)
+			continue
+		}
+		token := z.Token()
+		changed := false
+		for i, attr := range token.Attr {
+			if attr.Namespace != "" || (attr.Key != "href" && attr.Key != "src") {
+				continue
+			}
+			u, err := url.Parse(strings.TrimSpace(attr.Val))
+			if err != nil || u.IsAbs() {
+				continue
+			}
+			token.Attr[i].Val, changed = base.ResolveReference(u).String(), true
+		}
+		if changed {
+			out.WriteString(token.String())
+		} else {
+			out.Write(raw)
+		}
+	}
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+type atomText struct {
+	Type string `xml:"type,attr,omitempty"`
+	Body string `xml:",chardata"`
+}
+
+type atomEntry struct {
+	Base       string     `xml:"xml:base,attr"`
+	Title      string     `xml:"title"`
+	ID         string     `xml:"id"`
+	Link       atomLink   `xml:"link"`
+	Updated    string     `xml:"updated"`
+	Summary    *atomText  `xml:"summary,omitempty"`
+	Content    atomText   `xml:"content"`
+	Categories []atomTerm `xml:"category"`
+}
+
+type atomTerm struct {
+	Term string `xml:"term,attr"`
+}
+
+// atomFeed returns an atom 1.0 feed, with the content of posts relative to their url
+func atomFeed(info blogInfo, home, self string, updated time.Time, items []feedPost) ([]byte, error) {
+	// an author is required
+	author := info.Author
+	if author == "" {
+		author = info.Title
+	}
+	feed := struct {
+		XMLName  xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+		Title    string      `xml:"title"`
+		Subtitle string      `xml:"subtitle,omitempty"`
+		ID       string      `xml:"id"`
+		Links    []atomLink  `xml:"link"`
+		Updated  string      `xml:"updated"`
+		Author   string      `xml:"author>name"`
+		Entries  []atomEntry `xml:"entry"`
+	}{Title: info.Title, ID: home, Links: []atomLink{{Href: home}, {Href: self, Rel: "self", Type: "application/atom+xml"}},
+		Updated: updated.Format(time.RFC3339), Author: author}
+	if info.Description != info.Title {
+		feed.Subtitle = info.Description
+	}
+	for _, item := range items {
+		entry := atomEntry{Base: item.URL, Title: item.Title, ID: item.URL, Link: atomLink{Href: item.URL},
+			Updated: item.Date.Format(time.RFC3339), Content: atomText{Type: "html", Body: item.HTML}}
+		if item.Summary != "" {
+			entry.Summary = &atomText{Body: item.Summary}
+		}
+		for _, tag := range item.Tags {
+			entry.Categories = append(entry.Categories, atomTerm{Term: tag})
+		}
+		feed.Entries = append(feed.Entries, entry)
+	}
+	b, err := xml.MarshalIndent(feed, "", "  ")
+	return append([]byte(xml.Header), b...), err
+}
+
+// rssFeed returns an rss 2.0 feed
+func rssFeed(info blogInfo, home, self string, items []feedPost) ([]byte, error) {
+	type rssItem struct {
+		Title       string   `xml:"title"`
+		Link        string   `xml:"link"`
+		GUID        string   `xml:"guid"`
+		PubDate     string   `xml:"pubDate"`
+		Description string   `xml:"description"`
+		Categories  []string `xml:"category"`
+	}
+	feed := struct {
+		XMLName     xml.Name  `xml:"rss"`
+		Version     string    `xml:"version,attr"`
+		Atom        string    `xml:"xmlns:atom,attr"`
+		Title       string    `xml:"channel>title"`
+		Link        string    `xml:"channel>link"`
+		Self        atomLink  `xml:"channel>atom:link"`
+		Description string    `xml:"channel>description"`
+		Items       []rssItem `xml:"channel>item"`
+	}{Version: "2.0", Atom: "http://www.w3.org/2005/Atom", Title: info.Title, Link: home,
+		Self: atomLink{Href: self, Rel: "self", Type: "application/rss+xml"}, Description: info.Description}
+	for _, item := range items {
+		feed.Items = append(feed.Items, rssItem{Title: item.Title, Link: item.URL, GUID: item.URL,
+			PubDate: item.Date.Format(time.RFC1123Z), Description: item.HTML, Categories: item.Tags})
+	}
+	b, err := xml.MarshalIndent(feed, "", "  ")
+	return append([]byte(xml.Header), b...), err
+}
+
+// jsonFeed returns a json feed 1.1
+func jsonFeed(info blogInfo, home, self string, items []feedPost) ([]byte, error) {
+	type jsonItem struct {
+		ID            string   `json:"id"`
+		URL           string   `json:"url"`
+		Title         string   `json:"title"`
+		ContentHTML   string   `json:"content_html"`
+		Summary       string   `json:"summary,omitempty"`
+		DatePublished string   `json:"date_published"`
+		Tags          []string `json:"tags,omitempty"`
+	}
+	type jsonAuthor struct {
+		Name string `json:"name"`
+	}
+	feed := struct {
+		Version     string       `json:"version"`
+		Title       string       `json:"title"`
+		Description string       `json:"description,omitempty"`
+		HomePageURL string       `json:"home_page_url"`
+		FeedURL     string       `json:"feed_url"`
+		Authors     []jsonAuthor `json:"authors,omitempty"`
+		Items       []jsonItem   `json:"items"`
+	}{Version: "https://jsonfeed.org/version/1.1", Title: info.Title, Description: info.Description, HomePageURL: home, FeedURL: self, Items: []jsonItem{}}
+	if info.Author != "" {
+		feed.Authors = []jsonAuthor{{Name: info.Author}}
+	}
+	for _, item := range items {
+		feed.Items = append(feed.Items, jsonItem{ID: item.URL, URL: item.URL, Title: item.Title, ContentHTML: item.HTML,
+			Summary: item.Summary, DatePublished: item.Date.Format(time.RFC3339), Tags: item.Tags})
+	}
+	return json.MarshalIndent(feed, "", "  ")
+}
@@ -0,0 +1,160 @@
+package handler
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBlog(t *testing.T) {
+	dir := tempDocs(t, map[string]string{
+		"posts/2024-01-02-hello-world.md": "# Hello\n\nfirst [link](other.md)\n",
+		"posts/2024-02-03-untitled.md":    "no heading\n",
+		"posts/later.md":                  "---\ntitle: Later\ndate: 2024-03-04 10:00\ntags: [go, web]\nsummary: about go\n---\nlater\n",
+		"posts/sub/nested.md":             "---\ndate: 2024-04-05\ntags: go\n---\n# Nested\n",
+		"posts/draft.md":                  "---\ndate: 2024-05-06\ndraft: true\n---\n# Draft\n",
+		"posts/future.md":                 fmt.Sprintf("---\ndate: %s\ntags: go\n---\n# Future\n", time.Now().AddDate(1, 0, 0).Format("2006-01-02")),
+		"posts/undated.md":                "# Undated\n",
+		"about.md":                        "---\ndate: 2024-06-07\n---\n# Outside\n",
+	})
+
+	h := newHandler(t, Options{Root: DirFS(dir), BasePath: "/b", Blog: &Blog{Dir: "posts/", Title: "My Blog", URL: "https://example.com/", PageSize: 2}})
+	get := func(path string) (int, string) {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w.Code, w.Body.String()
+	}
+
+	for path, want := range map[string][]string{
+		"/posts/": {"<h1>My Blog</h1>", `href="/b/posts/sub/nested.md">Nested</a>`, `<time datetime="2024-04-05">April 5, 2024</time>`,
+			`href="/b/posts/later.md">Later</a>`, `<p>about go</p>`, `href="/b/tags/go/">#go</a>`,
+			`rel="next" href="/b/posts/page/2/"`, `href="/b/feed.atom"`},
+		"/posts/page/2/":     {`href="/b/posts/2024-02-03-untitled.md">untitled</a>`, `href="/b/posts/2024-01-02-hello-world.md">Hello</a>`, `rel="prev" href="/b/posts/"`},
+		"/tags/go/":          {"<h1>tags: go</h1>", "Nested", "Later"},
+		"/tags/web/":         {"Later"},
+		"/posts/hello-world": nil,
+	} {
+		code, body := get(path)
+		if want == nil {
+			if code != 404 {
+				t.Errorf("%s: got %d, want 404", path, code)
+			}
+			continue
+		}
+		if code != 200 {
+			t.Errorf("%s: got %d", path, code)
+		}
+		for _, s := range want {
+			if !strings.Contains(body, s) {
+				t.Errorf("%s: want %q in:\n%s", path, s, body)
+			}
+		}
+		for _, s := range []string{"Draft", "Future", "Undated", "Outside"} {
+			if strings.Contains(body, s) {
+				t.Errorf("%s: unwanted %q in:\n%s", path, s, body)
+			}
+		}
+	}
+	for _, path := range []string{"/posts/page/3/", "/posts/page/1/", "/posts/tags/go/", "/tags/none/", "/posts/other/"} {
+		if code, _ := get(path); code != 404 {
+			t.Errorf("%s: got %d, want 404", path, code)
+		}
+	}
+
+	// feeds have absolute urls
+	_, body := get("/feed.json")
+	var feed struct {
+		FeedURL string `json:"feed_url"`
+		Items   []struct {
+			URL         string
+			ContentHTML string `json:"content_html"`
+			Tags        []string
+		}
+	}
+	if err := json.Unmarshal([]byte(body), &feed); err != nil {
+		t.Fatal(err, body)
+	}
+	if feed.FeedURL != "https://example.com/b/feed.json" || len(feed.Items) != 4 ||
+		feed.Items[0].URL != "https://example.com/b/posts/sub/nested.md" || strings.Join(feed.Items[1].Tags, ",") != "go,web" ||
+		!strings.Contains(feed.Items[3].ContentHTML, `<a href="https://example.com/b/posts/other.md"`) {
+		t.Errorf("json feed:\n%s", body)
+	}
+	for _, path := range []string{"/feed.atom", "/feed.rss"} {
+		_, body := get(path)
+		if err := xml.Unmarshal([]byte(body), new(struct{})); err != nil {
+			t.Errorf("%s: %v\n%s", path, err, body)
+		}
+		for _, s := range []string{"https://example.com/b/posts/2024-01-02-hello-world.md", "&lt;h1", "<title>My Blog</title>"} {
+			if !strings.Contains(body, s) {
+				t.Errorf("%s: want %q in:\n%s", path, s, body)
+			}
+		}
+		if strings.Contains(body, "Draft") {
+			t.Errorf("%s: draft in feed", path)
+		}
+	}
+	_, body = get("/feed.atom")
+	if !strings.Contains(body, `xml:base="https://example.com/b/posts/2024-01-02-hello-world.md"`) {
+		t.Errorf("atom feed without xml:base:\n%s", body)
+	}
+
+	// described by the front matter of the index page, at the host and scheme a trusted proxy set
+	writeDocs(t, dir, map[string]string{"posts/index.md": "---\ntitle: Index title\ndescription: About things\nauthor: Ann\n---\n"})
+	h = newHandler(t, Options{Root: DirFS(dir), Index: "index.md", Blog: &Blog{Dir: "posts"}})
+	proxied := func(path, scheme string) string {
+		r := httptest.NewRequest("GET", path, nil)
+		r.URL.Host, r.URL.Scheme = r.Host, scheme
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w.Body.String()
+	}
+	if body := proxied("/posts/", ""); !strings.Contains(body, "<h1>Index title</h1>") || !strings.Contains(body, `href="/feed.atom"`) {
+		t.Errorf("listing without the index title or feeds:\n%s", body)
+	}
+	body = proxied("/feed.json", "https")
+	for _, s := range []string{`"title": "Index title"`, `"description": "About things"`, `"name": "Ann"`,
+		`"feed_url": "https://example.com/feed.json"`, `href=\"https://example.com/posts/other.md\"`} {
+		if !strings.Contains(body, s) {
+			t.Errorf("json feed: want %q in:\n%s", s, body)
+		}
+	}
+	for path, want := range map[string]string{"/feed.rss": "<description>About things</description>", "/feed.atom": "<name>Ann</name>"} {
+		if body := proxied(path, ""); !strings.Contains(body, want) {
+			t.Errorf("%s: want %q in:\n%s", path, want, body)
+		}
+	}
+
+	// never at the host of any client
+	if code, _ := get("/feed.rss"); code != 404 {
+		t.Errorf("feed without a site url: got %d, want 404", code)
+	}
+	if _, body := get("/posts/"); strings.Contains(body, "feed.atom") {
+		t.Errorf("listing links to feeds without a site url:\n%s", body)
+	}
+
+	// without any title, feeds are named after the host
+	os.Remove(filepath.Join(dir, "posts/index.md"))
+	if body := proxied("/feed.rss", ""); !strings.Contains(body, "<title>example.com</title>") || !strings.Contains(body, "<link>http://example.com/posts/</link>") {
+		t.Errorf("rss feed:\n%s", body)
+	}
+
+	// new posts are listed
+	writeDocs(t, dir, map[string]string{"posts/2024-07-08-new.md": "# New\n"})
+	if _, body := get("/posts/"); !strings.Contains(body, "New") {
+		t.Errorf("new post not listed:\n%s", body)
+	}
+
+	// the whole tree, with tags listed by the taxonomy
+	h = newHandler(t, Options{Root: DirFS(dir), Blog: &Blog{Dir: "."}, Taxonomies: []string{"categories"}})
+	for path, want := range map[string]string{"/": `href="/tags/go/">#go</a>`, "/tags/go/": "<h1>tags: go</h1>", "/categories/": "<h1>categories</h1>"} {
+		if code, body := get(path); code != 200 || !strings.Contains(body, want) {
+			t.Errorf("%s: got %d, want %q in:\n%s", path, code, want, body)
+		}
+	}
+}
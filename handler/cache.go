@@ -0,0 +1,109 @@
+package handler
+
+import (
+	"io/fs"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// maxCachedPages is how many rendered pages are kept
+const maxCachedPages = 1024
+
+// renderCache keeps rendered pages until they, or a file they include or link to with ref, change.
+// a nil renderCache caches nothing.
+type renderCache struct {
+	mu    sync.Mutex
+	pages map[string]*cachedPage
+}
+
+type cachedPage struct {
+	doc  document
+	deps map[string]fileState // the page and the files it depends on
+}
+
+func newRenderCache() *renderCache {
+	return &renderCache{pages: make(map[string]*cachedPage)}
+}
+
+// get returns the rendered page name, if neither it nor its includes were modified
+func (c *renderCache) get(fsys fs.FS, name string) (document, bool) {
+	if c == nil {
+		return document{}, false
+	}
+	c.mu.Lock()
+	page, ok := c.pages[name]
+	c.mu.Unlock()
+	if !ok {
+		return document{}, false
+	}
+	for dep, state := range page.deps {
+		if statFile(fsys, dep) != state {
+			return document{}, false
+		}
+	}
+	return page.doc, true
+}
+
+// put caches the rendered page name, with the state of its files before rendering
+func (c *renderCache) put(name string, doc document, deps map[string]fileState) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.pages[name]; !ok && len(c.pages) >= maxCachedPages {
+		for p := range c.pages {
+			delete(c.pages, p)
+			break
+		}
+	}
+	c.pages[name] = &cachedPage{doc: doc, deps: deps}
+}
+
+// forget drops the pages depending on name, which is a file or a directory,
+// and returns the other pages that included it
+func (c *renderCache) forget(name string) []string {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var includers []string
+	for p, page := range c.pages {
+		for dep := range page.deps {
+			if name == "." || dep == name || strings.HasPrefix(dep, name+"/") {
+				delete(c.pages, p)
+				if dep != p {
+					includers = append(includers, p)
+				}
+				break
+			}
+		}
+	}
+	sort.Strings(includers)
+	return includers
+}
+
+// statFile returns the state of a file, or the zero state if it can not be read
+func statFile(fsys fs.FS, name string) fileState {
+	fi, err := fs.Stat(fsys, name)
+	if err != nil {
+		return fileState{}
+	}
+	return fileState{modtime: fi.ModTime().UnixNano(), size: fi.Size()}
+}
+
+// renderFile renders page name, from the cache when it is up to date
+func (h *handler) renderFile(name string, src []byte) document {
+	if doc, ok := h.renderCache.get(h.Root, name); ok {
+		return doc
+	}
+	deps := map[string]fileState{name: statFile(h.Root, name)}
+	doc := h.renderPage(name, src)
+	for _, dep := range doc.deps {
+		deps[dep] = statFile(h.Root, dep)
+	}
+	h.renderCache.put(name, doc, deps)
+	return doc
+}
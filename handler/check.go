@@ -0,0 +1,265 @@
+package handler
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// BrokenLink is a link, anchor, or image in a page that would not be served
+type BrokenLink struct {
+	Page   string // markdown file containing the link
+	Link   string // href or src, as written in the rendered page
+	Reason string
+}
+
+func (b BrokenLink) String() string {
+	return fmt.Sprintf("%s: %s: %s", b.Page, b.Link, b.Reason)
+}
+
+// Check renders every markdown file in opts.Root, and returns the internal links,
+// anchors and images that would not be served, resolved like requests are
+func Check(opts Options) ([]BrokenLink, error) {
+	hh, err := New(opts)
+	if err != nil {
+		return nil, err
+	}
+	h := hh.(*handler)
+
+	var broken []BrokenLink
+	anchors := make(map[string]map[string]bool) // rendered anchors of each page
+	rendered := func(name string) (map[string]bool, error) {
+		if a, ok := anchors[name]; ok {
+			return a, nil
+		}
+		b, err := fs.ReadFile(h.Root, name)
+		if err != nil {
+			return nil, err
+		}
+		a := pageAnchors(h.renderPage(name, b).HTML)
+		anchors[name] = a
+		return a, nil
+	}
+
+	err = fs.WalkDir(h.Root, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if name != "." && !h.visible(name) {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() || !strings.HasSuffix(name, ".md") {
+			return nil
+		}
+		b, err := fs.ReadFile(h.Root, name)
+		if err != nil {
+			return err
+		}
+		for _, link := range pageLinks(h.renderPage(name, b).HTML) {
+			target, fragment, err := h.checkLink(name, link)
+			if err == nil && fragment != "" && strings.HasSuffix(target, ".md") {
+				var a map[string]bool
+				if a, err = rendered(target); err == nil && !a[fragment] {
+					err = fmt.Errorf("no anchor #%s in %s", fragment, target)
+				}
+			}
+			if err != nil {
+				broken = append(broken, BrokenLink{Page: name, Link: link, Reason: err.Error()})
+			}
+		}
+		return nil
+	})
+
+	// a markdown navigation file was checked like any page
+	if h.Nav != "" && !strings.HasSuffix(h.Nav, ".md") {
+		var check func(items []NavItem)
+		check = func(items []NavItem) {
+			for _, item := range items {
+				if item.Name != "" {
+					if err := h.checkInclude(item.Name); err != nil {
+						broken = append(broken, BrokenLink{Page: h.Nav, Link: item.Name, Reason: err.Error()})
+					}
+				}
+				check(item.Children)
+			}
+		}
+		if _, err := fs.Stat(h.Root, h.Nav); err != nil {
+			broken = append(broken, BrokenLink{Page: h.Nav, Link: h.Nav, Reason: "not found"})
+		}
+		check(h.navItems())
+	}
+	sort.SliceStable(broken, func(i, j int) bool { return broken[i].Page < broken[j].Page })
+	return broken, err
+}
+
+// checkLink resolves a link found in page name, and returns the file and anchor it points to.
+// external links are not checked.
+func (h *handler) checkLink(name, link string) (target, fragment string, err error) {
+	u, err := url.Parse(link)
+	if err != nil {
+		return "", "", err
+	}
+	if u.Scheme != "" || u.Host != "" {
+		return "", "", nil
+	}
+	if u.Path == "" {
+		return name, u.Fragment, nil
+	}
+
+	p, err := h.linkPath(name, u)
+	if err != nil {
+		return "", "", err
+	}
+	// escaped, for terms like ci%2Fcd
+	if ep, err := h.linkPath(name, &url.URL{Path: u.EscapedPath()}); err == nil && h.generated(ep) {
+		return "", u.Fragment, nil
+	}
+	target, err = h.resolve(p)
+	return target, u.Fragment, err
+}
+
+// generated returns true if escaped url path p is a page the handler generates, like a feed or a tag listing
+func (h *handler) generated(p string) bool {
+	u, err := url.Parse(p)
+	if err != nil {
+		return false
+	}
+	// feeds are served with any site url
+	u.Scheme, u.Host = "http", "localhost"
+	r := &http.Request{Method: "GET", URL: u, Header: make(http.Header)}
+	w := &discardWriter{header: make(http.Header)}
+	served := (h.Blog != nil && h.serveBlog(w, r)) || (h.taxonomy != nil && h.serveTaxonomy(w, r))
+	return served && (w.code == 0 || w.code == http.StatusOK)
+}
+
+// discardWriter keeps the status code of a response, and discards the rest
+type discardWriter struct {
+	header http.Header
+	code   int
+}
+
+func (w *discardWriter) Header() http.Header         { return w.header }
+func (w *discardWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (w *discardWriter) WriteHeader(code int) {
+	if w.code == 0 {
+		w.code = code
+	}
+}
+
+// linkPath returns the url path, without base path, of a link u found in page name
+func (h *handler) linkPath(name string, u *url.URL) (string, error) {
+	p := u.Path
+	if strings.HasPrefix(p, "/") {
+		if h.BasePath == "" {
+			return p, nil
+		}
+		if p != h.BasePath && !strings.HasPrefix(p, h.BasePath+"/") {
+			return "", errors.New("outside of the base path")
+		}
+		if p = strings.TrimPrefix(p, h.BasePath); p == "" {
+			p = "/"
+		}
+		return p, nil
+	}
+	p = path.Join(path.Dir("/"+name), p)
+	if strings.HasSuffix(u.Path, "/") && p != "/" {
+		p += "/"
+	}
+	return p, nil
+}
+
+// resolve returns the file served for url path p, like ServeHTTP does,
+// or why it is not served
+func (h *handler) resolve(p string) (string, error) {
+	if strings.Contains(p, "..") {
+		return "", errors.New("path contains '..'")
+	}
+	name, generated := h.fileName(p)
+	if generated {
+		if fi, err := fs.Stat(h.Root, name); err != nil || !fi.IsDir() {
+			return "", errors.New("not found")
+		}
+		if !h.fileisgood(name) {
+			return "", errors.New("refused (symlink)")
+		}
+		return name, nil
+	}
+	name = path.Clean(name)
+	if !fs.ValidPath(name) {
+		return "", errors.New("bad path")
+	}
+	name = h.preferMarkdown(name)
+	fi, err := fs.Stat(h.Root, name)
+	if err != nil {
+		return "", errors.New("not found")
+	}
+	if !h.fileisgood(name) {
+		return "", errors.New("refused (symlink)")
+	}
+	// directories are redirected to their index
+	if fi.IsDir() {
+		if strings.HasSuffix(p, "/") {
+			return "", errors.New("directory without index")
+		}
+		return h.resolve(p + "/")
+	}
+	return name, nil
+}
+
+// pageLinks returns the hrefs of links, and the sources of images, in rendered html
+func pageLinks(b []byte) []string {
+	var links []string
+	z := html.NewTokenizer(bytes.NewReader(b))
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			return links
+		}
+		if tt != html.StartTagToken && tt != html.SelfClosingTagToken {
+			continue
+		}
+		tag, hasAttr := z.TagName()
+		want := map[string]string{"a": "href", "img": "src"}[string(tag)]
+		for want != "" && hasAttr {
+			var key, val []byte
+			key, val, hasAttr = z.TagAttr()
+			if string(key) == want && len(val) > 0 {
+				links = append(links, string(val))
+			}
+		}
+	}
+}
+
+// pageAnchors returns the ids and anchor names in rendered html
+func pageAnchors(b []byte) map[string]bool {
+	anchors := make(map[string]bool)
+	z := html.NewTokenizer(bytes.NewReader(b))
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			return anchors
+		}
+		if tt != html.StartTagToken && tt != html.SelfClosingTagToken {
+			continue
+		}
+		tag, hasAttr := z.TagName()
+		for hasAttr {
+			var key, val []byte
+			key, val, hasAttr = z.TagAttr()
+			if string(key) == "id" || (string(key) == "name" && string(tag) == "a") {
+				anchors[string(val)] = true
+			}
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package handler
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	dir := tempDocs(t, map[string]string{
+		"index.md":     "# Home\n\n## Usage\n\n[usage](#usage) [top](#nope) [b](b.html) [b section](b.md#section) [b missing](b.md#missing)\n",
+		"b.md":         "## Section\n\n![logo](img/logo.png) ![gone](img/gone.png) [home](/) [sub](sub/) [sub dir](sub)\n",
+		"img/logo.png": "png",
+		"sub/index.md": "[up](../index.md) [empty](../empty/) [web](https://example.com/nope) [hidden](../.secret.md)\n",
+		".secret.md":   "secret",
+		"c.md":         "[link](link.md) [nope](nope.md)\n",
+	})
+	os.Mkdir(filepath.Join(dir, "empty"), 0755)
+	os.Symlink(filepath.Join(dir, "b.md"), filepath.Join(dir, "link.md"))
+
+	broken, err := Check(Options{Root: DirFS(dir), Index: "index.md"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, b := range broken {
+		got = append(got, b.String())
+	}
+	want := []string{
+		"b.md: img/gone.png: not found",
+		"c.md: link.md: refused (symlink)",
+		"c.md: nope.md: not found",
+		"index.md: #nope: no anchor #nope in index.md",
+		"index.md: b.md#missing: no anchor #missing in b.md",
+		"sub/index.md: ../empty/: not found",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("expected:\n%s\ngot:\n%s", strings.Join(want, "\n"), strings.Join(got, "\n"))
+	}
+}
+
+func TestCheckGenerated(t *testing.T) {
+	dir := tempDocs(t, map[string]string{
+		"posts/2024-01-02-first.md": "---\ntags: [go, ci/cd]\n---\n# First\n",
+		"index.md": "[atom](/feed.atom) [json](feed.json) [posts](posts/) [tag](/tags/go/) [tags](/tags/) [ci](/tags/ci%2Fcd/)\n\n" +
+			"[old](/posts/page/2/) [no tag](/tags/nope/)\n",
+	})
+
+	broken, err := Check(Options{Root: DirFS(dir), Index: "index.md", Blog: &Blog{Dir: "posts"}, Taxonomies: []string{"tags"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, b := range broken {
+		got = append(got, b.String())
+	}
+	want := []string{
+		"index.md: /posts/page/2/: not found",
+		"index.md: /tags/nope/: not found",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("expected:\n%s\ngot:\n%s", strings.Join(want, "\n"), strings.Join(got, "\n"))
+	}
+}
+
+func TestBrokenLinksPage(t *testing.T) {
+	dir := tempDocs(t, nil)
+	if _, err := New(Options{Root: DirFS(docsDir(t)), BrokenLinks: true}); err == nil {
+		t.Error("expected an error for BrokenLinks without Auth")
+	}
+	h := newHandler(t, Options{Root: DirFS(docsDir(t)), Index: "index.md", BrokenLinks: true, Auth: testUsers(t, dir)})
+	for i := 0; i < 2; i++ {
+		r := httptest.NewRequest("GET", "/nope.md", nil)
+		r.Header.Set("Referer", "http://example.com/index.md")
+		h.ServeHTTP(httptest.NewRecorder(), r)
+	}
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/index.md", nil))
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/_admin/broken-links", nil)
+	r.SetBasicAuth("alice", "secret")
+	h.ServeHTTP(w, r)
+	body := w.Body.String()
+	for _, want := range []string{
+		"<code>/nope.md</code></td><td>2</td>",
+		`<a href="http://example.com/index.md" rel="nofollow">http://example.com/index.md</a> (2)`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected %q in %q", want, body)
+		}
+	}
+	if strings.Contains(body, "<code>/index.md</code>") {
+		t.Error("found page should not be reported")
+	}
+
+	// editors only
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/_admin/broken-links", nil))
+	if w.Code != 401 {
+		t.Errorf("expected 401, got %d", w.Code)
+	}
+}
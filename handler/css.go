@@ -3,7 +3,7 @@
 // static/gh.css
 // DO NOT EDIT!
 
-package main
+package handler
 
 import (
 	"bytes"
@@ -0,0 +1,198 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+
+	"golang.org/x/net/webdav"
+)
+
+// url path of the webdav endpoint, after the base path
+const davPrefix = "/_dav"
+
+// rootDir returns the directory of a DirFS root, which webdav writes to
+func rootDir(root fs.FS) (string, bool) {
+	switch root := root.(type) {
+	case dirFS:
+		return string(root), true
+	case *archiveFS:
+		return rootDir(root.root)
+	default:
+		return "", false
+	}
+}
+
+// newDAV returns a webdav handler for the directory of Root
+func (h *handler) newDAV() (*webdav.Handler, error) {
+	dir, ok := rootDir(h.Root)
+	if !ok {
+		return nil, errors.New("handler: DAV needs a DirFS Root")
+	}
+	return &webdav.Handler{
+		Prefix:     h.BasePath + davPrefix,
+		FileSystem: &davFS{h: h, dir: webdav.Dir(dir)},
+		LockSystem: webdav.NewMemLS(),
+		Logger:     h.davLog,
+	}, nil
+}
+
+// serveDAV serves webdav requests under /_dav/ to authenticated users
+func (h *handler) serveDAV(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.Auth.Authenticate(r)
+	if !ok {
+		w.Header().Set("WWW-Authenticate", `Basic realm="markdownd", charset="UTF-8"`)
+		http.Error(w, "401 unauthorized", http.StatusUnauthorized)
+		return
+	}
+	h.Logger.Println("dav:", user, r.Method, r.URL.Path)
+
+	// webdav links and destinations include the base path
+	r2 := new(http.Request)
+	*r2 = *r
+	r2.URL = new(url.URL)
+	*r2.URL = *r.URL
+	r2.URL.Path = h.BasePath + r.URL.Path
+	h.dav.ServeHTTP(w, r2)
+}
+
+// davLog logs webdav errors, and tells about changed files
+func (h *handler) davLog(r *http.Request, err error) {
+	if err != nil {
+		h.Logger.Println("dav error:", r.Method, r.URL.Path, err)
+		return
+	}
+	switch r.Method {
+	case "PUT", "DELETE", "MKCOL", "MOVE", "COPY":
+	default:
+		return
+	}
+	if name, ok := h.davName(r.URL.Path); ok {
+		h.changed(name)
+	}
+	if dst, err := url.Parse(r.Header.Get("Destination")); err == nil && dst.Path != "" {
+		if name, ok := h.davName(dst.Path); ok {
+			h.changed(name)
+		}
+	}
+}
+
+// davName returns the name in Root of a webdav url path
+func (h *handler) davName(urlpath string) (string, bool) {
+	p := strings.TrimPrefix(urlpath, h.BasePath+davPrefix)
+	if len(p) == len(urlpath) {
+		return "", false
+	}
+	return fsName(p), true
+}
+
+// fsName turns a slash prefixed webdav name into a fs.FS name
+func fsName(name string) string {
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	if name == "" {
+		return "."
+	}
+	return name
+}
+
+// davFS is a webdav.Dir following the symlink and dotfile policy of the handler
+type davFS struct {
+	h   *handler
+	dir webdav.Dir
+}
+
+// allowed returns an error if name, or an existing directory leading to it, is refused
+func (d *davFS) allowed(op, name string) error {
+	n := fsName(name)
+	if n == "." {
+		return nil
+	}
+	if !d.h.Dotfiles && hidden(n) {
+		return &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	if d.h.Symlinks {
+		return nil
+	}
+	elems := strings.Split(n, "/")
+	for i := range elems {
+		fi, err := d.h.Root.(lstatFS).Lstat(path.Join(elems[:i+1]...))
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil || fi.Mode()&fs.ModeSymlink != 0 {
+			return &fs.PathError{Op: op, Path: name, Err: fs.ErrPermission}
+		}
+	}
+	return nil
+}
+
+func (d *davFS) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	if err := d.allowed("mkdir", name); err != nil {
+		return err
+	}
+	return d.dir.Mkdir(ctx, name, perm)
+}
+
+func (d *davFS) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	if err := d.allowed("open", name); err != nil {
+		return nil, err
+	}
+	f, err := d.dir.OpenFile(ctx, name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &davFile{File: f, h: d.h}, nil
+}
+
+func (d *davFS) RemoveAll(ctx context.Context, name string) error {
+	if fsName(name) == "." {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrPermission}
+	}
+	if err := d.allowed("remove", name); err != nil {
+		return err
+	}
+	return d.dir.RemoveAll(ctx, name)
+}
+
+func (d *davFS) Rename(ctx context.Context, oldName, newName string) error {
+	if err := d.allowed("rename", oldName); err != nil {
+		return err
+	}
+	if err := d.allowed("rename", newName); err != nil {
+		return err
+	}
+	return d.dir.Rename(ctx, oldName, newName)
+}
+
+func (d *davFS) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	if err := d.allowed("stat", name); err != nil {
+		return nil, err
+	}
+	return d.dir.Stat(ctx, name)
+}
+
+// davFile hides refused names from directory listings
+type davFile struct {
+	webdav.File
+	h *handler
+}
+
+func (f *davFile) Readdir(count int) ([]os.FileInfo, error) {
+	list, err := f.File.Readdir(count)
+	visible := list[:0]
+	for _, fi := range list {
+		if !f.h.Dotfiles && strings.HasPrefix(fi.Name(), ".") {
+			continue
+		}
+		if !f.h.Symlinks && fi.Mode()&os.ModeSymlink != 0 {
+			continue
+		}
+		visible = append(visible, fi)
+	}
+	return visible, err
+}
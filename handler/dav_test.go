@@ -0,0 +1,97 @@
+package handler
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDAV(t *testing.T) {
+	dir, err := ioutil.TempDir("", "markdownd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.Mkdir(filepath.Join(dir, ".git"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, ".git", "config"), []byte("secret"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "page.md"), []byte("# page\n"), 0644)
+	os.Symlink("/etc", filepath.Join(dir, "etc"))
+	users := testUsers(t, dir)
+
+	h := newHandler(t, Options{Root: DirFS(dir), DAV: true, Auth: users, LiveReload: true, BasePath: "/docs"})
+	changes := h.(*handler).reload.subscribe()
+
+	dav := func(method, target, body string, header ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.SetBasicAuth("alice", "secret")
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("PROPFIND", "/_dav/", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without login, got %d", w.Code)
+	}
+
+	// listings skip dotfiles and symlinks, and links include the base path
+	w = dav("PROPFIND", "/_dav/", "", "Depth", "1")
+	if w.Code != http.StatusMultiStatus {
+		t.Fatalf("expected 207, got %d", w.Code)
+	}
+	body := w.Body.String()
+	if !strings.Contains(body, "/docs/_dav/page.md") || strings.Contains(body, ".git") || strings.Contains(body, ".users") || strings.Contains(body, "_dav/etc") {
+		t.Errorf("unexpected listing: %s", body)
+	}
+
+	if w = dav("PUT", "/_dav/new.md", "# new\n"); w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d", w.Code)
+	}
+	if b, _ := ioutil.ReadFile(filepath.Join(dir, "new.md")); string(b) != "# new\n" {
+		t.Errorf("expected new.md to be written, got %q", b)
+	}
+	if name := <-changes; name != "new.md" {
+		t.Errorf("expected change of new.md, got %q", name)
+	}
+	if w = dav("MOVE", "/_dav/new.md", "", "Destination", "http://example.com/docs/_dav/moved.md"); w.Code != http.StatusCreated {
+		t.Fatalf("expected 201 for move, got %d", w.Code)
+	}
+	if a, b := <-changes, <-changes; a != "new.md" || b != "moved.md" {
+		t.Errorf("expected changes of new.md and moved.md, got %q %q", a, b)
+	}
+
+	for _, tc := range []struct{ method, target string }{
+		{"GET", "/_dav/.git/config"},
+		{"PUT", "/_dav/.git/config"},
+		{"GET", "/_dav/etc/passwd"},
+		{"PUT", "/_dav/etc/evil.md"},
+		{"DELETE", "/_dav/"},
+	} {
+		if w = dav(tc.method, tc.target, "evil"); w.Code < 400 {
+			t.Errorf("%s %s: expected refusal, got %d", tc.method, tc.target, w.Code)
+		}
+	}
+	if b, _ := ioutil.ReadFile(filepath.Join(dir, ".git", "config")); string(b) != "secret" {
+		t.Error("dotfile was written")
+	}
+
+	// plain requests serve dotfiles like .well-known, and pages listen for changes
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/.git/config", nil))
+	if w.Code != http.StatusOK || w.Body.String() != "secret" {
+		t.Errorf("expected dotfile over http, got %d %q", w.Code, w.Body.String())
+	}
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/page.md", nil))
+	if !strings.Contains(w.Body.String(), `new EventSource("/docs" + "/_reload")`) || !strings.Contains(w.Body.String(), `"page.md"`) {
+		t.Errorf("expected live reload script, got %q", w.Body.String())
+	}
+}
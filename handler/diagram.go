@@ -0,0 +1,231 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"html"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// diagrams are rendered by commands reading the source on stdin, and writing svg to stdout
+const (
+	diagramTimeout = 10 * time.Second
+	maxDiagramSize = 5 << 20
+	maxDiagrams    = 256 // cached
+	diagramRuns    = 4   // commands running at once
+	diagramRetry   = 30 * time.Second
+)
+
+// DefaultDiagrams returns commands for mermaid, graphviz and plantuml code blocks,
+// which need mmdc (mermaid-cli), dot and plantuml to be installed
+func DefaultDiagrams() map[string][]string {
+	return map[string][]string{
+		"dot":      {"dot", "-Tsvg"},
+		"graphviz": {"dot", "-Tsvg"},
+		"plantuml": {"plantuml", "-tsvg", "-pipe"},
+		"mermaid":  {"mmdc", "--input", "-", "--output", "-", "--outputFormat", "svg", "--quiet"},
+	}
+}
+
+// diagramCache keeps rendered diagrams by a hash of their command and source
+type diagramCache struct {
+	mu     sync.Mutex
+	svg    map[[32]byte][]byte
+	order  [][32]byte // oldest first
+	failed map[[32]byte]diagramFailure
+	runs   chan struct{} // semaphore of diagramRuns
+}
+
+type diagramFailure struct {
+	err   error
+	until time.Time
+}
+
+func newDiagramCache() *diagramCache {
+	return &diagramCache{
+		svg:    make(map[[32]byte][]byte),
+		failed: make(map[[32]byte]diagramFailure),
+		runs:   make(chan struct{}, diagramRuns),
+	}
+}
+
+// render returns the svg of a diagram, running command if it is not cached.
+// failures are only kept for diagramRetry, so installing a missing command works without a restart.
+func (c *diagramCache) render(command []string, src []byte) ([]byte, error) {
+	key := sha256.Sum256(append([]byte(strings.Join(command, "\x00")+"\x00\x00"), src...))
+	c.mu.Lock()
+	svg, ok := c.svg[key]
+	failure := c.failed[key]
+	c.mu.Unlock()
+	if ok {
+		return svg, nil
+	}
+	if time.Now().Before(failure.until) {
+		return nil, failure.err
+	}
+
+	c.runs <- struct{}{}
+	svg, err := runDiagram(command, src)
+	<-c.runs
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err != nil {
+		now := time.Now()
+		if len(c.failed) >= maxDiagrams {
+			for k, f := range c.failed {
+				if !now.Before(f.until) {
+					delete(c.failed, k)
+				}
+			}
+		}
+		if len(c.failed) < maxDiagrams {
+			c.failed[key] = diagramFailure{err: err, until: now.Add(diagramRetry)}
+		}
+		return nil, err
+	}
+	delete(c.failed, key)
+	if _, ok := c.svg[key]; !ok {
+		if len(c.order) >= maxDiagrams {
+			delete(c.svg, c.order[0])
+			c.order = c.order[1:]
+		}
+		c.svg[key] = svg
+		c.order = append(c.order, key)
+	}
+	return svg, nil
+}
+
+// runDiagram runs command with src on stdin, and returns the svg it writes
+func runDiagram(command []string, src []byte) ([]byte, error) {
+	if len(command) == 0 {
+		return nil, errors.New("no command")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), diagramTimeout)
+	defer cancel()
+	var stdout, stderr limitedBuffer
+	stdout.max, stderr.max = maxDiagramSize, 1<<10
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	cmd.Stdin = bytes.NewReader(src)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := firstLine(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%s: %v: %s", command[0], err, msg)
+		}
+		return nil, fmt.Errorf("%s: %v", command[0], err)
+	}
+	// without any xml declaration or doctype
+	svg := stdout.Bytes()
+	i := bytes.Index(svg, []byte("<svg"))
+	if i == -1 {
+		return nil, fmt.Errorf("%s: no svg in output", command[0])
+	}
+	return bytes.TrimSpace(svg[i:]), nil
+}
+
+// limitedBuffer fails writes past max bytes
+type limitedBuffer struct {
+	bytes.Buffer
+	max int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if b.Len()+len(p) > b.max {
+		return 0, errors.New("output too large")
+	}
+	return b.Buffer.Write(p)
+}
+
+func firstLine(s string) string {
+	s = strings.TrimSpace(s)
+	if i := strings.IndexByte(s, '\n'); i != -1 {
+		s = s[:i]
+	}
+	return s
+}
+
+// diagrams replaces fenced code blocks of diagram languages with placeholders for their svg.
+// blocks that can not be rendered are left as code, followed by the error.
+func (h *handler) diagrams(src []byte, ph *placeholders) []byte {
+	var out, block, body bytes.Buffer
+	var fence []byte
+	lang := ""
+	for _, line := range bytes.SplitAfter(src, []byte("\n")) {
+		trimmed := bytes.TrimLeft(line, " ")
+		switch {
+		case fence == nil:
+			if fence = opensFence(trimmed); fence == nil || len(line)-len(trimmed) >= 4 {
+				fence = nil
+				out.Write(line)
+				continue
+			}
+			lang = ""
+			if info := strings.Fields(string(trimmed[len(fence):])); len(info) > 0 {
+				if _, ok := h.Diagrams[info[0]]; ok {
+					lang = info[0]
+				}
+			}
+			if lang == "" {
+				out.Write(line)
+				continue
+			}
+			block.Reset()
+			body.Reset()
+			block.Write(line)
+		case closesFence(trimmed, fence):
+			fence = nil
+			if lang == "" {
+				out.Write(line)
+				continue
+			}
+			block.Write(line)
+			out.Write(h.diagram(lang, body.Bytes(), block.Bytes(), ph))
+		case lang == "":
+			out.Write(line)
+		default:
+			block.Write(line)
+			body.Write(line)
+		}
+	}
+	// unclosed block
+	if fence != nil && lang != "" {
+		out.Write(block.Bytes())
+	}
+	return out.Bytes()
+}
+
+// diagram returns markdown for a diagram, or for its code block and an error
+func (h *handler) diagram(lang string, src, block []byte, ph *placeholders) []byte {
+	svg, err := h.diagramCache.render(h.Diagrams[lang], src)
+	var out bytes.Buffer
+	if err != nil {
+		h.Logger.Printf("error rendering %s diagram: %v", lang, err)
+		out.Write(block)
+		out.WriteString("\n")
+		out.Write(ph.addBlock([]byte(fmt.Sprintf(`<p class="diagram-error">could not render %s diagram: %s</p>`,
+			html.EscapeString(lang), html.EscapeString(err.Error())))))
+		out.WriteString("\n\n")
+		return out.Bytes()
+	}
+
+	// svg can hold links and scripts, which do not run in images
+	class := "diagram diagram-" + html.EscapeString(lang)
+	var tag string
+	if h.Sanitizer.policy == nil {
+		tag = fmt.Sprintf(`<div class="%s">%s</div>`, class, svg)
+	} else {
+		tag = fmt.Sprintf(`<p><img class="%s" alt="%s diagram" src="data:image/svg+xml;base64,%s"></p>`,
+			class, html.EscapeString(lang), base64.StdEncoding.EncodeToString(svg))
+	}
+	out.WriteString("\n")
+	out.Write(ph.addBlock(h.Sanitizer.sanitize([]byte(tag))))
+	out.WriteString("\n\n")
+	return out.Bytes()
+}
@@ -0,0 +1,114 @@
+package handler
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDiagrams(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("no sh")
+	}
+	dir, err := ioutil.TempDir("", "markdownd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	count := filepath.Join(dir, "count")
+
+	// a renderer wrapping its input in svg, counting runs
+	diagrams := map[string][]string{
+		"dot":     {"sh", "-c", `echo run >> "$0"; echo '<?xml version="1.0"?>'; echo '<svg>'; cat; echo '</svg>'`, count},
+		"mermaid": {"no-such-diagram-renderer"},
+	}
+	src := []byte("text\n```dot\ndigraph { a -> b }\n```\n\n````go\n```dot\n````\n\n```mermaid\ngraph TD; A-->B\n```\n")
+
+	none, _ := NewSanitizer("none")
+	h := newHandler(t, Options{Diagrams: diagrams, Sanitizer: none}).(*handler)
+	out := string(h.markdown2html(src))
+	for _, want := range []string{
+		"<p>text</p>\n\n" + `<div class="diagram diagram-dot"><svg>` + "\ndigraph { a -> b }\n</svg></div>",
+		`<div class="highlight highlight-go"><pre>`,
+		`<p class="diagram-error">could not render mermaid diagram: no-such-diagram-renderer: `,
+		"graph TD; A--&gt;B",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected %q in %q", want, out)
+		}
+	}
+	if strings.Contains(out, "<?xml") || strings.Contains(out, "<p><div") {
+		t.Errorf("unexpected output %q", out)
+	}
+
+	// rendered once, then cached
+	h.markdown2html(src)
+	if b, _ := ioutil.ReadFile(count); strings.Count(string(b), "run") != 1 {
+		t.Errorf("expected one run, got %q", b)
+	}
+
+	// images, unless content is trusted
+	h = newHandler(t, Options{Diagrams: diagrams}).(*handler)
+	out = string(h.markdown2html([]byte("```dot\n<script>alert(1)</script>\n```\n")))
+	svg := base64.StdEncoding.EncodeToString([]byte("<svg>\n<script>alert(1)</script>\n</svg>"))
+	if want := `<p><img class="diagram diagram-dot" alt="dot diagram" src="data:image/svg+xml;base64,` + svg + `"></p>`; !strings.Contains(out, want) {
+		t.Errorf("expected %q in %q", want, out)
+	}
+}
+
+func TestDiagramCache(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("no sh")
+	}
+	dir, err := ioutil.TempDir("", "markdownd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	count := filepath.Join(dir, "count")
+	c := newDiagramCache()
+
+	// failures are kept for a while
+	fail := []string{"sh", "-c", `echo run >> "$0"; echo broken >&2; exit 1`, count}
+	for i := 0; i < 3; i++ {
+		if _, err := c.render(fail, []byte("a")); err == nil || !strings.Contains(err.Error(), "broken") {
+			t.Fatalf("expected an error, got %v", err)
+		}
+	}
+	if b, _ := ioutil.ReadFile(count); strings.Count(string(b), "run") != 1 {
+		t.Errorf("expected one run, got %q", b)
+	}
+	for key, f := range c.failed {
+		c.failed[key] = diagramFailure{err: f.err, until: time.Now()}
+	}
+	c.render(fail, []byte("a"))
+	if b, _ := ioutil.ReadFile(count); strings.Count(string(b), "run") != 2 {
+		t.Errorf("expected a second run after the failure expired, got %q", b)
+	}
+
+	// no more than diagramRuns commands at once
+	for i := 0; i < diagramRuns; i++ {
+		c.runs <- struct{}{}
+	}
+	done := make(chan struct{})
+	go func() {
+		c.render([]string{"sh", "-c", "echo '<svg/>'"}, []byte("b"))
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Fatal("rendered while every run was taken")
+	case <-time.After(50 * time.Millisecond):
+	}
+	<-c.runs
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("not rendered after a run was freed")
+	}
+}
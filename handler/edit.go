@@ -0,0 +1,224 @@
+package handler
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// maximum size of a saved markdown file
+const maxEditSize = 10 << 20
+
+// etag of file contents, empty for files that do not exist yet
+func etag(b []byte, exists bool) string {
+	if !exists {
+		return ""
+	}
+	sum := sha256.Sum256(b)
+	return fmt.Sprintf(`"%x"`, sum[:8])
+}
+
+var editTemplate = template.Must(template.New("edit").Parse(`<form method="post" action="{{.Action}}" class="editor">
+{{with .Error}}<p class="error"><strong>{{.}}</strong></p>
+{{end}}<input type="hidden" name="etag" value="{{.ETag}}">
+<textarea name="text" rows="30" style="width: 100%; font-family: monospace" autofocus>{{.Text}}</textarea>
+<p><input name="message" placeholder="summary of changes" size="60" value="{{.Message}}">
+<button name="action" value="preview">Preview</button>
+<button name="action" value="save">Save</button>
+<a href="{{.Cancel}}">Cancel</a></p>
+</form>
+<div id="preview" class="preview">{{.Preview}}</div>
+<script>
+(function() {
+	var text = document.querySelector("form.editor textarea");
+	var preview = document.getElementById("preview");
+	var timer;
+	text.addEventListener("input", function() {
+		clearTimeout(timer);
+		timer = setTimeout(function() {
+			fetch("?preview", {method: "POST", credentials: "same-origin", body: new URLSearchParams({text: text.value})})
+				.then(function(r) { return r.text(); })
+				.then(function(html) { preview.innerHTML = html; });
+		}, 300);
+	});
+})();
+</script>
+`))
+
+type editPage struct {
+	Action, Cancel string
+	ETag           string
+	Text, Message  string
+	Preview        template.HTML
+	Error          string
+}
+
+// editable returns true if name is a markdown file that can be written
+func (h *handler) editable(name string) bool {
+	if !strings.HasSuffix(name, ".md") {
+		return false
+	}
+	if _, ok := h.Root.(WriteFS); !ok {
+		return false
+	}
+	// the file may not exist yet, but its directory must
+	dir := path.Dir(name)
+	if fi, err := fs.Stat(h.Root, dir); err != nil || !fi.IsDir() || !h.fileisgood(dir) {
+		return false
+	}
+	fi, err := fs.Stat(h.Root, name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return h.Dotfiles || !hidden(name)
+	}
+	return err == nil && fi.Mode().IsRegular() && h.fileisgood(name)
+}
+
+// editQuery returns true for requests like /README.md?edit
+func editQuery(r *http.Request) bool {
+	_, ok := r.URL.Query()["edit"]
+	return ok
+}
+
+// sameOrigin refuses cross site form posts, which browsers send with the saved credentials
+func sameOrigin(r *http.Request) bool {
+	if r.Header.Get("Sec-Fetch-Site") == "cross-site" {
+		return false
+	}
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Host == r.Host
+}
+
+// serveEdit serves the editor of name, previews, and saves
+func (h *handler) serveEdit(w http.ResponseWriter, r *http.Request, name string) {
+	user, ok := h.Auth.Authenticate(r)
+	if !ok {
+		w.Header().Set("WWW-Authenticate", `Basic realm="markdownd", charset="UTF-8"`)
+		http.Error(w, "401 unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if !h.editable(name) {
+		h.Logger.Printf("error: %q is not editable", name)
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method == "POST" && !sameOrigin(r) {
+		h.Logger.Println("cross site post refused:", r.Header.Get("Origin"))
+		http.Error(w, "403 forbidden", http.StatusForbidden)
+		return
+	}
+
+	current, err := fs.ReadFile(h.Root, name)
+	exists := err == nil
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		h.Logger.Println("error reading file:", err)
+		http.NotFound(w, r)
+		return
+	}
+	self := h.BasePath + r.URL.Path
+	p := editPage{
+		Action: self + "?edit",
+		Cancel: self,
+		ETag:   etag(current, exists),
+		Text:   string(current),
+	}
+
+	if r.Method != "POST" {
+		w.Header().Set("ETag", p.ETag)
+		h.serveEditor(w, r, name, p, http.StatusOK)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxEditSize)
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "400 bad request", http.StatusBadRequest)
+		return
+	}
+	// textareas send CRLF line endings
+	text := strings.Replace(r.PostForm.Get("text"), "\r\n", "\n", -1)
+
+	// live preview, written into the editor page
+	if _, ok := r.URL.Query()["preview"]; ok {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(h.renderPage(name, []byte(text)).HTML)
+		return
+	}
+
+	p.Text = text
+	p.Message = r.PostForm.Get("message")
+	p.Preview = template.HTML(h.renderPage(name, []byte(text)).HTML)
+	if r.PostForm.Get("action") == "preview" {
+		p.ETag = r.PostForm.Get("etag")
+		h.serveEditor(w, r, name, p, http.StatusOK)
+		return
+	}
+
+	// only save over the version the editor started from
+	h.editMu.Lock()
+	defer h.editMu.Unlock()
+	match := r.Header.Get("If-Match")
+	if match == "" {
+		match = r.PostForm.Get("etag")
+	}
+	current, err = fs.ReadFile(h.Root, name)
+	if now := etag(current, err == nil); match != now {
+		h.Logger.Printf("%s: stale save of %q refused", user, name)
+		p.ETag = now
+		p.Error = "This page was changed since you started editing it. Copy your changes, and reload the page to edit the new version."
+		h.serveEditor(w, r, name, p, http.StatusPreconditionFailed)
+		return
+	}
+	if err := h.Root.(WriteFS).WriteFile(name, []byte(text)); err != nil {
+		h.Logger.Println("error saving file:", err)
+		http.Error(w, "500 internal server error", http.StatusInternalServerError)
+		return
+	}
+	h.Logger.Printf("%s: saved %q", user, name)
+	h.changed(name)
+
+	if h.GitCommit && h.Git != "" {
+		if err := h.commit(name, user, p.Message); err != nil {
+			h.Logger.Println("error committing:", err)
+		}
+		h.commitCache.forget(name)
+	}
+	http.Redirect(w, r, self, http.StatusSeeOther)
+}
+
+// serveEditor writes the editor page between the header and footer templates
+func (h *handler) serveEditor(w http.ResponseWriter, r *http.Request, name string, p editPage, code int) {
+	var buf bytes.Buffer
+	if err := editTemplate.Execute(&buf, p); err != nil {
+		h.Logger.Println("error executing edit template:", err)
+		http.Error(w, "500 internal server error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	h.serveDocument(w, r, name, document{HTML: buf.Bytes()}, code)
+}
+
+// commit name to git, as the editing user
+func (h *handler) commit(name, user, message string) error {
+	author := user + " <>"
+	if id, ok := h.Auth.(identifier); ok {
+		author = id.Identity(user)
+	}
+	if message == "" {
+		message = "Edit " + name
+	}
+	if _, err := git(h.Git, "add", "--", literalPath(name)); err != nil {
+		return err
+	}
+	_, err := git(h.Git, "commit", "--quiet", "--no-verify", "--author="+author, "-m", message, "--", literalPath(name))
+	return err
+}
@@ -0,0 +1,158 @@
+package handler
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// testUsers writes a users file with alice:secret
+func testUsers(t *testing.T, dir string) *Users {
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := filepath.Join(dir, ".users")
+	if err := ioutil.WriteFile(name, []byte("# editors\nalice:"+string(hash)+":Alice <alice@example.com>\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	users, err := LoadUsers(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return users
+}
+
+func editRequest(method, target string, form url.Values) *http.Request {
+	var req *http.Request
+	if form == nil {
+		req = httptest.NewRequest(method, target, nil)
+	} else {
+		req = httptest.NewRequest(method, target, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	req.SetBasicAuth("alice", "secret")
+	return req
+}
+
+func TestEdit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "markdownd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "page.md"), []byte("# old\n"), 0644)
+	os.Symlink("/etc/passwd", filepath.Join(dir, "passwd.md"))
+	h := newHandler(t, Options{Root: DirFS(dir), Edit: true, Auth: testUsers(t, dir)})
+
+	// editors must log in
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/page.md?edit", nil))
+	if w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") == "" {
+		t.Fatalf("expected 401 with WWW-Authenticate, got %d", w.Code)
+	}
+	req := httptest.NewRequest("GET", "/page.md?edit", nil)
+	req.SetBasicAuth("alice", "wrong")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 with a wrong password, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, editRequest("GET", "/page.md?edit", nil))
+	tag := w.Header().Get("ETag")
+	if w.Code != 200 || tag == "" || !strings.Contains(w.Body.String(), "# old") {
+		t.Fatalf("expected editor with ETag, got %d %q", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, editRequest("POST", "/page.md?preview", url.Values{"text": {"# preview"}}))
+	if !strings.Contains(w.Body.String(), "<h1>") || !strings.Contains(w.Body.String(), "preview") {
+		t.Errorf("expected rendered preview, got %q", w.Body.String())
+	}
+
+	// save, then refuse a save based on the old version
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, editRequest("POST", "/page.md?edit", url.Values{"text": {"# new\r\n"}, "etag": {tag}}))
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("expected 303 after save, got %d %q", w.Code, w.Body.String())
+	}
+	if b, _ := ioutil.ReadFile(filepath.Join(dir, "page.md")); string(b) != "# new\n" {
+		t.Errorf("expected saved file, got %q", b)
+	}
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, editRequest("POST", "/page.md?edit", url.Values{"text": {"# stale"}, "etag": {tag}}))
+	if w.Code != http.StatusPreconditionFailed || !strings.Contains(w.Body.String(), "# stale") || w.Header().Get("Content-Type") != "text/html" {
+		t.Errorf("expected a 412 html page keeping the text, got %d %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	if b, _ := ioutil.ReadFile(filepath.Join(dir, "page.md")); string(b) != "# new\n" {
+		t.Errorf("expected stale save to be refused, got %q", b)
+	}
+
+	// new pages, symlinks, and cross site posts
+	for _, tc := range []struct {
+		target, origin string
+		code           int
+	}{
+		{"/created.md?edit", "", http.StatusSeeOther},
+		{"/passwd.md?edit", "", http.StatusNotFound},
+		{"/missing/page.md?edit", "", http.StatusNotFound},
+		{"/text.txt?edit", "", http.StatusNotFound},
+		{"/other.md?edit", "https://evil.example.com", http.StatusForbidden},
+	} {
+		req := editRequest("POST", tc.target, url.Values{"text": {"hello"}})
+		if tc.origin != "" {
+			req.Header.Set("Origin", tc.origin)
+		}
+		w = httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != tc.code {
+			t.Errorf("%s: expected %d, got %d", tc.target, tc.code, w.Code)
+		}
+	}
+	if fi, err := os.Lstat(filepath.Join(dir, "passwd.md")); err != nil || fi.Mode()&os.ModeSymlink == 0 {
+		t.Error("expected symlink to be left alone")
+	}
+
+	// without Edit, POST is refused like before
+	h = newHandler(t, Options{Root: DirFS(dir)})
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, editRequest("POST", "/page.md?edit", url.Values{"text": {"x"}}))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 without Edit, got %d", w.Code)
+	}
+}
+
+func TestEditCommit(t *testing.T) {
+	dir := testRepo(t)
+	os.Setenv("GIT_COMMITTER_NAME", "markdownd")
+	os.Setenv("GIT_COMMITTER_EMAIL", "markdownd@example.com")
+	defer os.Unsetenv("GIT_COMMITTER_NAME")
+	defer os.Unsetenv("GIT_COMMITTER_EMAIL")
+
+	h := newHandler(t, Options{Root: DirFS(dir), Git: dir, Edit: true, GitCommit: true, Auth: testUsers(t, dir)})
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, editRequest("GET", "/sub/page.md?edit", nil))
+	form := url.Values{"text": {"edited\n"}, "etag": {w.Header().Get("ETag")}, "message": {"Fix the sub page"}}
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, editRequest("POST", "/sub/page.md?edit", form))
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("expected 303 after save, got %d", w.Code)
+	}
+	out, err := exec.Command("git", "-C", dir, "log", "-1", "--format=%an <%ae> %s", "--", "sub/page.md").Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.TrimSpace(string(out)); got != "Alice <alice@example.com> Fix the sub page" {
+		t.Errorf("expected commit by Alice, got %q", got)
+	}
+}
@@ -0,0 +1,28 @@
+package handler
+
+import (
+	"regexp"
+	"sync"
+
+	"github.com/kyokomi/emoji/v2"
+)
+
+var (
+	emojiRegexp = regexp.MustCompile(`:[a-z0-9_+\-]+:`)
+	emojiOnce   sync.Once
+	emojiCodes  map[string]string // like ":smile:" to "😄"
+)
+
+// emojiShortcodes replaces github emoji shortcodes like :tada: outside of code
+// with the emoji. unknown shortcodes are left alone.
+func emojiShortcodes(src []byte) []byte {
+	emojiOnce.Do(func() { emojiCodes = emoji.CodeMap() })
+	return mapText(src, func(text []byte) []byte {
+		return emojiRegexp.ReplaceAllFunc(text, func(code []byte) []byte {
+			if e, ok := emojiCodes[string(code)]; ok {
+				return []byte(e)
+			}
+			return code
+		})
+	})
+}
@@ -0,0 +1,127 @@
+package handler
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	bf "github.com/russross/blackfriday"
+)
+
+// Extensions are the markdown parser extensions and html renderer flags used by both
+// rendering modes, instead of their defaults
+type Extensions struct {
+	name       string
+	extensions int
+	htmlFlags  int
+}
+
+// markdown parser extensions, by name
+var extensionNames = map[string]int{
+	"no-intra-emphasis":          bf.EXTENSION_NO_INTRA_EMPHASIS,
+	"tables":                     bf.EXTENSION_TABLES,
+	"fenced-code":                bf.EXTENSION_FENCED_CODE,
+	"autolink":                   bf.EXTENSION_AUTOLINK,
+	"strikethrough":              bf.EXTENSION_STRIKETHROUGH,
+	"lax-html-blocks":            bf.EXTENSION_LAX_HTML_BLOCKS,
+	"space-headings":             bf.EXTENSION_SPACE_HEADERS,
+	"hard-line-break":            bf.EXTENSION_HARD_LINE_BREAK,
+	"tab-size-eight":             bf.EXTENSION_TAB_SIZE_EIGHT,
+	"footnotes":                  bf.EXTENSION_FOOTNOTES,
+	"no-empty-line-before-block": bf.EXTENSION_NO_EMPTY_LINE_BEFORE_BLOCK,
+	"heading-ids":                bf.EXTENSION_HEADER_IDS,
+	"titleblock":                 bf.EXTENSION_TITLEBLOCK,
+	"auto-heading-ids":           bf.EXTENSION_AUTO_HEADER_IDS,
+	"backslash-line-break":       bf.EXTENSION_BACKSLASH_LINE_BREAK,
+	"definition-lists":           bf.EXTENSION_DEFINITION_LISTS,
+	"join-lines":                 bf.EXTENSION_JOIN_LINES,
+}
+
+// html renderer flags, by name. flags making something other than a page body,
+// like a complete page or blackfriday's own table of contents, are left out.
+var htmlFlagNames = map[string]int{
+	"skip-html":                 bf.HTML_SKIP_HTML,
+	"skip-style":                bf.HTML_SKIP_STYLE,
+	"skip-images":               bf.HTML_SKIP_IMAGES,
+	"skip-links":                bf.HTML_SKIP_LINKS,
+	"safelink":                  bf.HTML_SAFELINK,
+	"nofollow-links":            bf.HTML_NOFOLLOW_LINKS,
+	"noreferrer-links":          bf.HTML_NOREFERRER_LINKS,
+	"noopener-links":            bf.HTML_NOOPENER_LINKS,
+	"href-target-blank":         bf.HTML_HREF_TARGET_BLANK,
+	"xhtml":                     bf.HTML_USE_XHTML,
+	"smartypants":               bf.HTML_USE_SMARTYPANTS,
+	"smartypants-fractions":     bf.HTML_SMARTYPANTS_FRACTIONS,
+	"smartypants-dashes":        bf.HTML_SMARTYPANTS_DASHES,
+	"smartypants-latex-dashes":  bf.HTML_SMARTYPANTS_LATEX_DASHES,
+	"smartypants-angled-quotes": bf.HTML_SMARTYPANTS_ANGLED_QUOTES,
+	"smartypants-quotes-nbsp":   bf.HTML_SMARTYPANTS_QUOTES_NBSP,
+	"footnote-return-links":     bf.HTML_FOOTNOTE_RETURN_LINKS,
+}
+
+// presets of extensions and html flags
+var extensionPresets = map[string]Extensions{
+	// markdown.pl syntax, nothing more
+	"strict": {},
+	// fenced code and heading anchors
+	"minimal": {extensions: bf.EXTENSION_FENCED_CODE | bf.EXTENSION_SPACE_HEADERS | bf.EXTENSION_AUTO_HEADER_IDS},
+	// as close to commonmark as blackfriday gets
+	"commonmark": {extensions: bf.EXTENSION_FENCED_CODE | bf.EXTENSION_SPACE_HEADERS | bf.EXTENSION_NO_INTRA_EMPHASIS |
+		bf.EXTENSION_BACKSLASH_LINE_BREAK | bf.EXTENSION_AUTO_HEADER_IDS},
+	// the default with github flavored rendering
+	"gfm": {extensions: gfmExtensions | bf.EXTENSION_AUTO_HEADER_IDS},
+}
+
+// NewExtensions returns extensions from a comma separated list of presets, extensions
+// and html flags. A name starting with '-' is removed from what comes before it:
+//
+//	"commonmark,tables,smartypants"
+//	"gfm,-autolink"
+func NewExtensions(spec string) (*Extensions, error) {
+	e := &Extensions{name: spec}
+	for _, name := range strings.Split(spec, ",") {
+		name = strings.TrimSpace(name)
+		remove := strings.HasPrefix(name, "-")
+		name = strings.TrimPrefix(name, "-")
+		var ext, flags int
+		if p, ok := extensionPresets[name]; ok {
+			ext, flags = p.extensions, p.htmlFlags
+		} else if x, ok := extensionNames[name]; ok {
+			ext = x
+		} else if x, ok := htmlFlagNames[name]; ok {
+			flags = x
+		} else if name != "" {
+			return nil, fmt.Errorf("unknown markdown extension %q", name)
+		}
+		if remove {
+			e.extensions &^= ext
+			e.htmlFlags &^= flags
+		} else {
+			e.extensions |= ext
+			e.htmlFlags |= flags
+		}
+	}
+	return e, nil
+}
+
+// Name of the extensions, as given to NewExtensions
+func (e *Extensions) Name() string {
+	return e.name
+}
+
+// ExtensionNames returns the names of presets, extensions and html flags known to NewExtensions
+func ExtensionNames() (presets, extensions, htmlFlags []string) {
+	for name := range extensionPresets {
+		presets = append(presets, name)
+	}
+	for name := range extensionNames {
+		extensions = append(extensions, name)
+	}
+	for name := range htmlFlagNames {
+		htmlFlags = append(htmlFlags, name)
+	}
+	sort.Strings(presets)
+	sort.Strings(extensions)
+	sort.Strings(htmlFlags)
+	return presets, extensions, htmlFlags
+}
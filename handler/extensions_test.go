@@ -0,0 +1,67 @@
+package handler
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/russross/blackfriday"
+)
+
+func TestNewExtensions(t *testing.T) {
+	for _, tc := range []struct {
+		spec              string
+		extensions, flags int
+	}{
+		{"strict", 0, 0},
+		{"gfm", gfmExtensions | blackfriday.EXTENSION_AUTO_HEADER_IDS, 0},
+		{"gfm, -autolink ,-auto-heading-ids", gfmExtensions &^ blackfriday.EXTENSION_AUTOLINK, 0},
+		{"minimal,tables,smartypants", extensionPresets["minimal"].extensions | blackfriday.EXTENSION_TABLES, blackfriday.HTML_USE_SMARTYPANTS},
+		{"tables,strict", blackfriday.EXTENSION_TABLES, 0},
+		{"commonmark,-commonmark", 0, 0},
+	} {
+		e, err := NewExtensions(tc.spec)
+		if err != nil {
+			t.Errorf("%q: %v", tc.spec, err)
+			continue
+		}
+		if e.extensions != tc.extensions || e.htmlFlags != tc.flags || e.Name() != tc.spec {
+			t.Errorf("%q: got %+v", tc.spec, e)
+		}
+	}
+	if _, err := NewExtensions("gfm,tabels"); err == nil {
+		t.Error("expected error for unknown extension")
+	}
+}
+
+func TestExtensions(t *testing.T) {
+	table := "a | b\n--- | ---\n1 | 2\n"
+	for _, tc := range []struct {
+		spec    string
+		plain   bool
+		src     string
+		want    string
+		notwant string
+	}{
+		{"", true, table, "", "<table>"},
+		{"gfm", true, table, "<table>", ""},
+		{"", false, table, "<table>", ""},
+		{"strict", false, table, "", "<table>"},
+		{"strict", true, "# Title\n", "<h1>Title</h1>", "id="},
+		{"minimal", true, "# Title\n", `<h1 id="title">Title</h1>`, ""},
+		{"commonmark,smartypants,smartypants-dashes", false, `"quoted" -- text`, "“quoted” — text", ""},
+		{"commonmark", false, `"quoted" -- text`, "&#34;quoted&#34; -- text", ""},
+	} {
+		opts := Options{Plain: tc.plain}
+		if tc.spec != "" {
+			e, err := NewExtensions(tc.spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			opts.Extensions = e
+		}
+		out := string(newHandler(t, opts).(*handler).markdown2html([]byte(tc.src)))
+		if !strings.Contains(out, tc.want) || (tc.notwant != "" && strings.Contains(out, tc.notwant)) {
+			t.Errorf("%q plain=%v: unexpected output %q", tc.spec, tc.plain, out)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package handler
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// frontMatter splits yaml front matter, between '---' lines at the start of a page,
+// from the markdown after it. pages without front matter are returned unchanged.
+//
+//	---
+//	title: Formulas
+//	math: true
+//	---
+func frontMatter(src []byte) (map[string]interface{}, []byte) {
+	first := bytes.SplitAfterN(src, []byte("\n"), 2)
+	if len(first) != 2 || string(bytes.TrimRight(first[0], "\r\n")) != "---" {
+		return nil, src
+	}
+	rest := first[1]
+	for i := 0; i < len(rest); {
+		end := bytes.IndexByte(rest[i:], '\n')
+		if end == -1 {
+			end = len(rest) - i
+		}
+		line := string(bytes.TrimRight(rest[i:i+end], "\r"))
+		if line == "---" || line == "..." {
+			meta := make(map[string]interface{})
+			if err := yaml.Unmarshal(rest[:i], &meta); err != nil {
+				return nil, src
+			}
+			if i+end < len(rest) {
+				end++
+			}
+			return meta, rest[i+end:]
+		}
+		i += end + 1
+	}
+	return nil, src
+}
+
+// metaBool returns a boolean front matter value, or def
+func metaBool(meta map[string]interface{}, key string, def bool) bool {
+	if b, ok := meta[key].(bool); ok {
+		return b
+	}
+	return def
+}
+
+// metaStrings returns a front matter list, or comma separated string, like tags
+func metaStrings(meta map[string]interface{}, key string) []string {
+	var list []string
+	switch v := meta[key].(type) {
+	case []interface{}:
+		for _, s := range v {
+			if s := strings.TrimSpace(fmt.Sprint(s)); s != "" {
+				list = append(list, s)
+			}
+		}
+	case string:
+		for _, s := range strings.Split(v, ",") {
+			if s := strings.TrimSpace(s); s != "" {
+				list = append(list, s)
+			}
+		}
+	}
+	return list
+}
+
+// dateLayouts are the front matter dates understood by metaTime
+var dateLayouts = []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02"}
+
+// metaTime returns a front matter date, like 2006-01-02 or an RFC 3339 time
+func metaTime(meta map[string]interface{}, key string) (time.Time, bool) {
+	switch v := meta[key].(type) {
+	case time.Time:
+		return v, true
+	case string:
+		for _, layout := range dateLayouts {
+			if t, err := time.Parse(layout, strings.TrimSpace(v)); err == nil {
+				return t, true
+			}
+		}
+	}
+	return time.Time{}, false
+}
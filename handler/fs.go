@@ -0,0 +1,153 @@
+package handler
+
+import (
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// lstatFS is a fs.FS that can tell symlinks apart
+type lstatFS interface {
+	fs.FS
+	Lstat(name string) (fs.FileInfo, error)
+}
+
+// WriteFS is a filesystem that can replace files, for Options.Edit
+type WriteFS interface {
+	fs.FS
+	// WriteFile replaces name with data atomically, or creates it
+	WriteFile(name string, data []byte) error
+}
+
+// DirFS returns a filesystem for the files in dir, like os.DirFS,
+// that can also tell symlinks apart, and implements WriteFS
+func DirFS(dir string) fs.FS {
+	return dirFS(dir)
+}
+
+// dirFS implements lstatFS
+type dirFS string
+
+func (dir dirFS) Open(name string) (fs.File, error) {
+	fullname, err := dir.join("open", name)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(fullname)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (dir dirFS) Lstat(name string) (fs.FileInfo, error) {
+	fullname, err := dir.join("lstat", name)
+	if err != nil {
+		return nil, err
+	}
+	return os.Lstat(fullname)
+}
+
+// WriteFile writes a temporary file next to name, and renames it over name,
+// so readers see either the old or the new file
+func (dir dirFS) WriteFile(name string, data []byte) error {
+	fullname, err := dir.join("write", name)
+	if err != nil {
+		return err
+	}
+	perm := fs.FileMode(0644)
+	if fi, err := os.Lstat(fullname); err == nil {
+		if !fi.Mode().IsRegular() {
+			return &fs.PathError{Op: "write", Path: name, Err: fs.ErrPermission}
+		}
+		perm = fi.Mode().Perm()
+	}
+	f, err := ioutil.TempFile(filepath.Dir(fullname), "."+filepath.Base(fullname)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(f.Name(), perm); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), fullname)
+}
+
+func (dir dirFS) join(op, name string) (string, error) {
+	if !fs.ValidPath(name) {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	return filepath.Join(string(dir), filepath.FromSlash(name)), nil
+}
+
+// fileisgood returns true if name can be served, following the symlink policy
+func (h *handler) fileisgood(name string) bool {
+	if h.Symlinks {
+		return fs.ValidPath(name)
+	}
+	return fileisgood(h.Root, name)
+}
+
+// fileisgood returns false if name, or any directory leading to it, is a symlink.
+// filesystems without lstat (like zip files or embed.FS) are checked with stat.
+func fileisgood(fsys fs.FS, name string) bool {
+
+	// sanity check
+	if name == "" || !fs.ValidPath(name) {
+		return false
+	}
+	if name == "." {
+		return true
+	}
+
+	lstat := func(name string) (fs.FileInfo, error) { return fs.Stat(fsys, name) }
+	if l, ok := fsys.(lstatFS); ok {
+		lstat = l.Lstat
+	}
+
+	// check every path element: a/b/c checks a, a/b, and a/b/c
+	elems := strings.Split(name, "/")
+	for i := range elems {
+		fi, err := lstat(path.Join(elems[:i+1]...))
+		if err != nil {
+			return false
+		}
+		if fi.Mode()&fs.ModeSymlink != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// visible returns true if name can be served, and is not a dotfile like .git unless Dotfiles.
+// walks of the tree and includes use it, requests for a dotfile are served.
+func (h *handler) visible(name string) bool {
+	return (h.Dotfiles || !hidden(name)) && h.fileisgood(name)
+}
+
+// hidden returns true if name, or a directory leading to it, starts with '.'
+func hidden(name string) bool {
+	if name == "." {
+		return false
+	}
+	for _, elem := range strings.Split(name, "/") {
+		if strings.HasPrefix(elem, ".") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,295 @@
+package handler
+
+// The github flavored markdown renderer below is adapted from
+// github.com/shurcooL/github_flavored_markdown, without the built-in
+// sanitization step, so that the sanitize policy can be applied the
+// same way to every rendering mode. Its license:
+//
+// MIT License
+//
+// Copyright (c) 2014 Dmitri Shuralyov
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/russross/blackfriday"
+	"github.com/shurcooL/highlight_diff"
+	"github.com/shurcooL/highlight_go"
+	"github.com/shurcooL/sanitized_anchor_name"
+	"github.com/sourcegraph/annotate"
+	"github.com/sourcegraph/syntaxhighlight"
+	"golang.org/x/net/html"
+)
+
+// gfmExtensions for github flavored markdown-like parsing.
+const gfmExtensions = blackfriday.EXTENSION_NO_INTRA_EMPHASIS |
+	blackfriday.EXTENSION_TABLES |
+	blackfriday.EXTENSION_FENCED_CODE |
+	blackfriday.EXTENSION_AUTOLINK |
+	blackfriday.EXTENSION_STRIKETHROUGH |
+	blackfriday.EXTENSION_SPACE_HEADERS |
+	blackfriday.EXTENSION_NO_EMPTY_LINE_BEFORE_BLOCK
+
+// gfmMarkdown renders github flavored markdown, unsanitized.
+// extensions are usually gfmExtensions, with more.
+func gfmMarkdown(text []byte, htmlFlags, extensions int, params blackfriday.HtmlRendererParameters) []byte {
+	r := &gfmRenderer{Html: blackfriday.HtmlRendererWithParameters(htmlFlags, "", "", params).(*blackfriday.Html)}
+	return blackfriday.Markdown(text, r, extensions)
+}
+
+type gfmRenderer struct {
+	*blackfriday.Html
+}
+
+// Header renders a heading with clickable and hidden anchor.
+func (*gfmRenderer) Header(out *bytes.Buffer, text func() bool, level int, _ string) {
+	marker := out.Len()
+	doubleSpace(out)
+
+	if !text() {
+		out.Truncate(marker)
+		return
+	}
+
+	textHTML := out.String()[marker:]
+	out.Truncate(marker)
+
+	// extract text content of the heading
+	var textContent string
+	if node, err := html.Parse(strings.NewReader(textHTML)); err == nil {
+		textContent = extractText(node)
+	} else {
+		textContent = html.UnescapeString(textHTML)
+	}
+	anchorName := sanitized_anchor_name.Create(textContent)
+
+	out.WriteString(fmt.Sprintf(`<h%d><a name="%s" class="anchor" href="#%s" rel="nofollow" aria-hidden="true"><span class="octicon octicon-link"></span></a>`, level, anchorName, anchorName))
+	out.WriteString(textHTML)
+	out.WriteString(fmt.Sprintf("</h%d>\n", level))
+}
+
+// extractText returns the recursive concatenation of the text content of an html node.
+func extractText(n *html.Node) string {
+	var out string
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.TextNode {
+			out += c.Data
+		} else {
+			out += extractText(c)
+		}
+	}
+	return out
+}
+
+// BlockCode renders a fenced code block with highlighting.
+func (*gfmRenderer) BlockCode(out *bytes.Buffer, text []byte, lang string) {
+	doubleSpace(out)
+
+	// parse out the language name
+	count := 0
+	for _, elt := range strings.Fields(lang) {
+		if elt[0] == '.' {
+			elt = elt[1:]
+		}
+		if len(elt) == 0 {
+			continue
+		}
+		out.WriteString(`<div class="highlight highlight-`)
+		attrEscape(out, []byte(elt))
+		lang = elt
+		out.WriteString(`"><pre>`)
+		count++
+		break
+	}
+
+	if count == 0 {
+		out.WriteString("<pre><code>")
+	}
+
+	if highlightedCode, ok := highlightCode(text, lang); ok {
+		out.Write(highlightedCode)
+	} else {
+		attrEscape(out, text)
+	}
+
+	if count == 0 {
+		out.WriteString("</code></pre>\n")
+	} else {
+		out.WriteString("</pre></div>\n")
+	}
+}
+
+// ListItem adds task list support.
+func (r *gfmRenderer) ListItem(out *bytes.Buffer, text []byte, flags int) {
+	switch {
+	case bytes.HasPrefix(text, []byte("[ ] ")):
+		text = append([]byte(`<input type="checkbox" disabled="">`), text[3:]...)
+	case bytes.HasPrefix(text, []byte("[x] ")) || bytes.HasPrefix(text, []byte("[X] ")):
+		text = append([]byte(`<input type="checkbox" checked="" disabled="">`), text[3:]...)
+	}
+	r.Html.ListItem(out, text, flags)
+}
+
+var gfmHTMLConfig = syntaxhighlight.HTMLConfig{
+	String:        "s",
+	Keyword:       "k",
+	Comment:       "c",
+	Type:          "n",
+	Literal:       "o",
+	Punctuation:   "p",
+	Plaintext:     "n",
+	Tag:           "tag",
+	HTMLTag:       "htm",
+	HTMLAttrName:  "atn",
+	HTMLAttrValue: "atv",
+	Decimal:       "m",
+}
+
+func highlightCode(src []byte, lang string) (highlightedCode []byte, ok bool) {
+	switch lang {
+	case "Go", "Go-unformatted":
+		var buf bytes.Buffer
+		err := highlight_go.Print(src, &buf, syntaxhighlight.HTMLPrinter(gfmHTMLConfig))
+		if err != nil {
+			return nil, false
+		}
+		return buf.Bytes(), true
+	case "diff":
+		anns, err := highlight_diff.Annotate(src)
+		if err != nil {
+			return nil, false
+		}
+
+		lines := bytes.Split(src, []byte("\n"))
+		lineStarts := make([]int, len(lines))
+		var offset int
+		for lineIndex := 0; lineIndex < len(lines); lineIndex++ {
+			lineStarts[lineIndex] = offset
+			offset += len(lines[lineIndex]) + 1
+		}
+
+		lastDel, lastIns := -1, -1
+		for lineIndex := 0; lineIndex < len(lines); lineIndex++ {
+			var lineFirstChar byte
+			if len(lines[lineIndex]) > 0 {
+				lineFirstChar = lines[lineIndex][0]
+			}
+			switch lineFirstChar {
+			case '+':
+				if lastIns == -1 {
+					lastIns = lineIndex
+				}
+			case '-':
+				if lastDel == -1 {
+					lastDel = lineIndex
+				}
+			default:
+				if lastDel != -1 || lastIns != -1 {
+					if lastDel == -1 {
+						lastDel = lastIns
+					} else if lastIns == -1 {
+						lastIns = lineIndex
+					}
+
+					beginOffsetLeft := lineStarts[lastDel]
+					endOffsetLeft := lineStarts[lastIns]
+					beginOffsetRight := lineStarts[lastIns]
+					endOffsetRight := lineStarts[lineIndex]
+
+					anns = append(anns, &annotate.Annotation{Start: beginOffsetLeft, End: endOffsetLeft, Left: []byte(`<span class="gd input-block">`), Right: []byte(`</span>`), WantInner: 0})
+					anns = append(anns, &annotate.Annotation{Start: beginOffsetRight, End: endOffsetRight, Left: []byte(`<span class="gi input-block">`), Right: []byte(`</span>`), WantInner: 0})
+
+					if '@' != lineFirstChar {
+						// filter out the "-" and "+" at the beginning of each line from being highlighted
+						leftContent := ""
+						for line := lastDel; line < lastIns; line++ {
+							leftContent += "\x00" + string(lines[line][1:]) + "\n"
+						}
+						rightContent := ""
+						for line := lastIns; line < lineIndex; line++ {
+							rightContent += "\x00" + string(lines[line][1:]) + "\n"
+						}
+
+						var sectionSegments [2][]*annotate.Annotation
+						highlight_diff.HighlightedDiffFunc(leftContent, rightContent, &sectionSegments, [2]int{beginOffsetLeft, beginOffsetRight})
+
+						anns = append(anns, sectionSegments[0]...)
+						anns = append(anns, sectionSegments[1]...)
+					}
+				}
+				lastDel, lastIns = -1, -1
+			}
+		}
+
+		sort.Sort(anns)
+
+		out, err := annotate.Annotate(src, anns, template.HTMLEscape)
+		if err != nil {
+			return nil, false
+		}
+		return out, true
+	default:
+		return nil, false
+	}
+}
+
+// unexported blackfriday helpers
+
+func doubleSpace(out *bytes.Buffer) {
+	if out.Len() > 0 {
+		out.WriteByte('\n')
+	}
+}
+
+func escapeSingleChar(char byte) (string, bool) {
+	switch char {
+	case '"':
+		return "&quot;", true
+	case '&':
+		return "&amp;", true
+	case '<':
+		return "&lt;", true
+	case '>':
+		return "&gt;", true
+	}
+	return "", false
+}
+
+func attrEscape(out *bytes.Buffer, src []byte) {
+	org := 0
+	for i, ch := range src {
+		if entity, ok := escapeSingleChar(ch); ok {
+			if i > org {
+				// copy all the normal characters since the last escape
+				out.Write(src[org:i])
+			}
+			org = i + 1
+			out.WriteString(entity)
+		}
+	}
+	if org < len(src) {
+		out.Write(src[org:])
+	}
+}
@@ -0,0 +1,209 @@
+package handler
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// git runs a git command in dir, and returns its output
+func git(dir string, args ...string) ([]byte, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("git %s: %s", args[0], msg)
+		}
+		return nil, fmt.Errorf("git %s: %v", args[0], err)
+	}
+	return out, nil
+}
+
+// refs look like branch names, tags, hashes, or HEAD~2, and never like options
+var validRefRegexp = regexp.MustCompile(`^[A-Za-z0-9_.@{}^~][A-Za-z0-9_./@{}^~-]*$`)
+
+// gitFS is a read only filesystem for one revision of a directory in a git repository.
+// files are read from the object store, not the work tree.
+type gitFS struct {
+	dir    string            // any directory inside the repository
+	commit string            // resolved commit hash
+	files  memFS             // names, modes and sizes, without data
+	blobs  map[string]string // blob hash of each regular file
+}
+
+// GitFS returns a filesystem for revision ref of dir, which is inside a git repository.
+// only files beneath dir are served, as they were in ref.
+// symlinks are kept as symlinks, so they are refused like on disk.
+func GitFS(dir, ref string) (fs.FS, error) {
+	commit, err := resolveRef(dir, ref)
+	if err != nil {
+		return nil, err
+	}
+	return gitTree(dir, commit)
+}
+
+// resolveRef returns the commit hash of ref
+func resolveRef(dir, ref string) (string, error) {
+	if !validRefRegexp.MatchString(ref) {
+		return "", fmt.Errorf("bad git ref: %q", ref)
+	}
+	out, err := git(dir, "rev-parse", "--verify", "--quiet", ref+"^{commit}")
+	if err != nil {
+		return "", fmt.Errorf("unknown git ref: %q", ref)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// gitTree lists the tree of dir at commit
+func gitTree(dir, commit string) (*gitFS, error) {
+	prefix, err := git(dir, "rev-parse", "--show-prefix")
+	if err != nil {
+		return nil, err
+	}
+	out, err := git(dir, "show", "-s", "--format=%ct", commit)
+	if err != nil {
+		return nil, err
+	}
+	seconds, err := strconv.ParseInt(strings.TrimSpace(string(out)), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("bad commit time: %v", err)
+	}
+	modtime := time.Unix(seconds, 0)
+
+	tree := commit + ":" + strings.TrimSuffix(strings.TrimSpace(string(prefix)), "/")
+	out, err = git(dir, "ls-tree", "-r", "-t", "-l", "-z", tree)
+	if err != nil {
+		return nil, err
+	}
+
+	g := &gitFS{
+		dir:    dir,
+		commit: commit,
+		files:  memFS{".": &memFile{name: ".", mode: fs.ModeDir | 0755, modtime: modtime}},
+		blobs:  make(map[string]string),
+	}
+	for _, line := range bytes.Split(out, []byte{0}) {
+		// <mode> SP <type> SP <object> SP <size> TAB <path>
+		tab := bytes.IndexByte(line, '\t')
+		if tab == -1 {
+			continue
+		}
+		fields := strings.Fields(string(line[:tab]))
+		name := string(line[tab+1:])
+		if len(fields) != 4 || !fs.ValidPath(name) {
+			continue
+		}
+		f := &memFile{name: name, modtime: modtime}
+		switch fields[0] {
+		case "040000":
+			f.mode = fs.ModeDir | 0755
+		case "120000":
+			f.mode = fs.ModeSymlink | 0777
+		case "100644", "100755":
+			f.mode = 0644
+			g.blobs[name] = fields[2]
+			size, _ := strconv.Atoi(fields[3])
+			f.data = make([]byte, 0, size) // only for Size()
+		default:
+			// submodules
+			continue
+		}
+		g.files.add(f)
+	}
+	return g, nil
+}
+
+func (g *gitFS) Open(name string) (fs.File, error) {
+	f, ok := g.files[name]
+	if !ok || !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	if f.mode.IsDir() {
+		return g.files.Open(name)
+	}
+	blob, ok := g.blobs[name]
+	if !ok {
+		// symlink
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrPermission}
+	}
+	data, err := git(g.dir, "cat-file", "blob", blob)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	file := *f
+	file.data = data
+	return &openMemFile{memFile: &file, Reader: bytes.NewReader(data)}, nil
+}
+
+func (g *gitFS) Lstat(name string) (fs.FileInfo, error) {
+	f, ok := g.files[name]
+	if !ok || !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "lstat", Path: name, Err: fs.ErrNotExist}
+	}
+	return f, nil
+}
+
+// GitRefs returns a function for Options.Refs, serving any revision of dir.
+// trees are listed once per commit, and kept for the most recent commits.
+func GitRefs(dir string) RefsFunc {
+	var (
+		mu    sync.Mutex
+		cache = make(map[string]*gitFS)
+		order []string
+	)
+	const max = 8
+	return func(ref string) (fs.FS, error) {
+		commit, err := resolveRef(dir, ref)
+		if err != nil {
+			return nil, err
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if g, ok := cache[commit]; ok {
+			return g, nil
+		}
+		g, err := gitTree(dir, commit)
+		if err != nil {
+			return nil, err
+		}
+		if len(order) == max {
+			delete(cache, order[0])
+			order = order[1:]
+		}
+		cache[commit] = g
+		order = append(order, commit)
+		return g, nil
+	}
+}
+
+var errNoRefs = errors.New("revisions are not enabled")
+
+// rootFor returns the filesystem for the request, which can ask for ?ref=
+func (h *handler) rootFor(ref string) (fs.FS, error) {
+	if ref == "" {
+		return h.Root, nil
+	}
+	if h.Refs == nil {
+		return nil, errNoRefs
+	}
+	return h.Refs(ref)
+}
+
+// withRef adds the ref query of r to link, if revisions are enabled and there is one
+func (h *handler) withRef(link string, r *http.Request) string {
+	if ref := r.URL.Query().Get("ref"); ref != "" && h.Refs != nil {
+		return link + "?ref=" + url.QueryEscape(ref)
+	}
+	return link
+}
@@ -0,0 +1,171 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testRepo creates a git repository with two commits, tagged v1 and v2
+func testRepo(t *testing.T) string {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found")
+	}
+	dir := tempDocs(t, map[string]string{
+		"index.md":    "# first version\n",
+		"sub/page.md": "sub page\n",
+	})
+
+	run := func(args ...string) {
+		cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+		cmd.Env = append(os.Environ(),
+			"GIT_AUTHOR_NAME=Tester", "GIT_AUTHOR_EMAIL=tester@example.com",
+			"GIT_COMMITTER_NAME=Tester", "GIT_COMMITTER_EMAIL=tester@example.com",
+			"GIT_CONFIG_NOSYSTEM=1", "HOME="+dir,
+		)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %s: %v\n%s", args[0], err, out)
+		}
+	}
+
+	run("init", "-q")
+	if err := os.Symlink("/etc/passwd", filepath.Join(dir, "passwd.md")); err != nil {
+		t.Fatal(err)
+	}
+	run("add", "-A")
+	run("commit", "-q", "-m", "first")
+	run("tag", "v1")
+	writeDocs(t, dir, map[string]string{"index.md": "# second version\n"})
+	run("commit", "-q", "-a", "-m", "second")
+	run("tag", "v2")
+	writeDocs(t, dir, map[string]string{"index.md": "# work tree\n"})
+	return dir
+}
+
+func TestServeGitRef(t *testing.T) {
+	dir := testRepo(t)
+	root, err := GitFS(dir, "v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := GitFS(dir, "--output=/tmp/x"); err == nil {
+		t.Error("expected refs like options to be refused")
+	}
+	h := newHandler(t, Options{Root: root})
+	for _, tc := range []struct {
+		path string
+		code int
+		want string
+	}{
+		{"/index.md", 200, "first version"},
+		{"/sub/page.md", 200, "sub page"},
+		{"/sub", 301, ""},
+		{"/passwd.md", 404, ""},
+		{"/missing.md", 404, ""},
+	} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", tc.path, nil))
+		if w.Code != tc.code || !strings.Contains(w.Body.String(), tc.want) {
+			t.Errorf("%s: expected %d %q, got %d %q", tc.path, tc.code, tc.want, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestServeRefQuery(t *testing.T) {
+	dir := testRepo(t)
+	h := newHandler(t, Options{Root: DirFS(dir), Refs: GitRefs(dir), GenerateIndex: true})
+	for _, tc := range []struct {
+		path string
+		code int
+		want string
+	}{
+		{"/index.md", 200, "work tree"},
+		{"/index.md?ref=v1", 200, "first version"},
+		{"/index.md?ref=v2", 200, "second version"},
+		{"/index.md?ref=HEAD~1", 200, "first version"},
+		{"/passwd.md?ref=v1", 404, ""},
+		{"/index.md?ref=nope", 404, ""},
+		{"/index.md?ref=-v", 404, ""},
+		{"/?ref=v1", 200, `href="/sub/?ref=v1"`},
+		{"/sub?ref=v1", 301, ""},
+	} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", tc.path, nil))
+		if w.Code != tc.code || !strings.Contains(w.Body.String(), tc.want) {
+			t.Errorf("%s: expected %d %q, got %d %q", tc.path, tc.code, tc.want, w.Code, w.Body.String())
+		}
+	}
+
+	// revisions are only served when enabled, otherwise ?ref= is ignored
+	h = newHandler(t, Options{Root: DirFS(dir), GenerateIndex: true})
+	for _, tc := range []struct{ path, want string }{
+		{"/index.md?ref=v1", "work tree"},
+		{"/index.md?ref=producthunt", "work tree"},
+		{"/?ref=producthunt", `href="/sub/"`},
+	} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", tc.path, nil))
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), tc.want) {
+			t.Errorf("%s without Refs: expected 200 %q, got %d %q", tc.path, tc.want, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestGitHistory(t *testing.T) {
+	dir := testRepo(t)
+	h := newHandler(t, Options{
+		Root:   DirFS(dir),
+		Git:    dir,
+		Refs:   GitRefs(dir),
+		Footer: []byte(`{{with .Git}}<p>by {{.Author}}: {{.Subject}}</p>{{end}}`),
+	})
+	for _, tc := range []struct {
+		path string
+		code int
+		want []string
+	}{
+		{"/index.md", 200, []string{"work tree", "by Tester: second"}},
+		{"/index.md?ref=v1", 200, []string{"first version", "by Tester: first"}},
+		{"/index.md?history", 200, []string{"first", "second", `href="/index.md?ref=`, "changes since"}},
+		{"/index.md?history&ref=v1", 200, []string{"first", "&ref=v1"}},
+		{"/index.md?diff=v1", 200, []string{"Changes to index.md", "--- a/index.md", "+++ b/index.md", "ee</span>"}},
+		{"/index.md?diff=v1&ref=v2", 200, []string{"econd</span> version"}},
+		{"/index.md?diff=v2&ref=v2", 200, []string{"no changes"}},
+		{"/index.md?diff=--output=x", 404, nil},
+		{"/passwd.md?history", 404, nil},
+	} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", tc.path, nil))
+		if w.Code != tc.code {
+			t.Errorf("%s: expected %d, got %d", tc.path, tc.code, w.Code)
+		}
+		for _, want := range tc.want {
+			if !strings.Contains(w.Body.String(), want) {
+				t.Errorf("%s: expected %q in %q", tc.path, want, w.Body.String())
+			}
+		}
+	}
+	// the last commit is kept until the file changes
+	c := h.(*handler).commitCache
+	c.mu.Lock()
+	cached := c.commits["HEAD\x00index.md"]
+	cached.commit = &Commit{Author: "Tester", Subject: "cached"}
+	c.commits["HEAD\x00index.md"] = cached
+	c.mu.Unlock()
+	get := func() string {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/index.md", nil))
+		return w.Body.String()
+	}
+	if body := get(); !strings.Contains(body, "by Tester: cached") {
+		t.Errorf("last commit not cached: %q", body)
+	}
+	h.(*handler).changed("index.md")
+	if body := get(); !strings.Contains(body, "by Tester: second") {
+		t.Errorf("last commit not forgotten: %q", body)
+	}
+}
@@ -0,0 +1,490 @@
+// Package handler serves markdown, static, and html files.
+//
+// Markdown files are rendered to html, between an optional header and footer.
+// A request for a .html file is served from the .md file of the same name, if it exists.
+// Symlinks and paths containing '..' are refused.
+//
+//	h, err := handler.New(handler.Options{
+//		Root:  handler.DirFS("docs"),
+//		Index: "README.md",
+//	})
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	http.Handle("/", h)
+package handler
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/webdav"
+)
+
+// Options configure a markdown handler
+type Options struct {
+	Root            fs.FS               // files to serve
+	Index           string              // filename to use for paths ending in '/'
+	GenerateIndex   bool                // generate a directory listing for paths ending in '/', instead of Index
+	Header          []byte              // html template written before rendered markdown
+	Footer          []byte              // html template written after rendered markdown
+	Plain           bool                // disable github flavored markdown
+	TOC             bool                // write a table of contents at the top of pages without a [TOC] line
+	TOCDepth        int                 // deepest heading level in tables of contents, defaults to 3
+	Math            bool                // render $inline$ and $$display$$ LaTeX formulas to MathML, front matter 'math' overrides
+	Diagrams        map[string][]string // commands rendering code blocks of a language to svg, see DefaultDiagrams
+	Alerts          bool                // render github alerts, blockquotes starting with a line like "> [!NOTE]"
+	Footnotes       bool                // render [^1] footnotes, with links back to the references
+	DefinitionLists bool                // render a "Term" line followed by ": definition" lines as a definition list
+	Emoji           bool                // replace github emoji shortcodes like :tada:
+	Includes        bool                // expand {{< include "file.md" >}} and {{< snippet "file.go" region="name" >}}
+	Nav             string              // file in Root ordering pages for .Nav, .Breadcrumbs, .Prev and .Next in templates, like SUMMARY.md or nav.yaml
+	Shortcodes      *Shortcodes         // expand {{< name >}} shortcodes and {{ .Site.Name }} variables, see NewShortcodes
+	Extensions      *Extensions         // markdown extensions and html flags, replacing the defaults of either mode
+	Taxonomies      []string            // front matter fields like "tags", listed at /tags/ and /tags/name/, and as json at /_api/taxonomies
+	Blog            *Blog               // list dated posts on pages of their directory, with feeds at /feed.atom, /feed.rss and /feed.json, and "tags" in Taxonomies
+	Syntax          bool                // serve /gh.css for syntax highlighting
+	Raw             bool                // serve markdown source for requests like /README.md?raw
+	Symlinks        bool                // follow symlinks instead of refusing them
+	Archives        bool                // browse zip and tar files in Root like directories, at /name.zip/
+	Refs            RefsFunc            // filesystem for requests like /README.md?ref=v1.0, see GitRefs
+	Git             string              // git work tree directory of Root, for .Git in templates, ?history and ?diff=rev
+	Edit            bool                // serve ?edit pages and save markdown files with POST, needs Auth and a WriteFS Root
+	Auth            Authenticator       // editors, see LoadUsers
+	GitCommit       bool                // commit saved files as the editor, with Git
+	DAV             bool                // serve a DirFS Root over webdav at /_dav/, needs Auth
+	LiveReload      bool                // reload open pages when their file changes
+	Dotfiles        bool                // allow names starting with '.', like .git, over webdav, in the editor, includes and page indexes
+	Wiki            SlugFunc            // resolve [[Page Name]] links to files named by this rule, see Slug
+	Backlinks       bool                // index links between pages, for .Backlinks in templates and /_api/links
+	Watch           time.Duration       // poll Root for changed files this often, for live reload and backlinks
+	BrokenLinks     bool                // count 404 responses and their referers, for /_admin/broken-links, needs Auth
+	Sanitizer       *Sanitizer          // html sanitization policy, defaults to "ugc"
+	BasePath        string              // url path prefix, like "/docs" when behind a reverse proxy
+	Server          string              // Server header value
+	Logger          *log.Logger         // request logs, defaults to none
+}
+
+// RefsFunc returns the filesystem of a revision
+type RefsFunc func(ref string) (fs.FS, error)
+
+// handler handles markdown requests
+type handler struct {
+	Options
+	header, footer *template.Template // for not-raw markdown requests
+	editMu         *sync.Mutex        // one save at a time
+	dav            *webdav.Handler    // with DAV
+	reload         *reloader          // changed files, for live reload
+	links          *linkIndex         // with Backlinks
+	taxonomy       *termIndex         // with Taxonomies
+	notFound       *notFoundLog       // with BrokenLinks
+	diagramCache   *diagramCache      // with Diagrams
+	renderCache    *renderCache       // without Wiki and Diagrams, which depend on more than files
+	navCache       *navCache          // with Nav
+	blogCache      *blogCache         // with Blog
+	commitCache    *commitCache       // with Git
+	stop           chan struct{}      // closed by Close, to stop watching
+	stopOnce       *sync.Once
+}
+
+// New returns a http.Handler serving opts.Root.
+// it is also an io.Closer, closing it stops polling Root with opts.Watch.
+func New(opts Options) (http.Handler, error) {
+	if opts.Root == nil {
+		return nil, errors.New("handler: no Root filesystem")
+	}
+	if opts.Sanitizer == nil {
+		opts.Sanitizer, _ = NewSanitizer("ugc")
+	}
+	if opts.Logger == nil {
+		opts.Logger = log.New(ioutil.Discard, "", 0)
+	}
+	opts.BasePath = CleanBasePath(opts.BasePath)
+	if opts.DAV && opts.Auth == nil {
+		return nil, errors.New("handler: DAV needs Auth")
+	}
+	if opts.BrokenLinks && opts.Auth == nil {
+		return nil, errors.New("handler: BrokenLinks needs Auth")
+	}
+	if opts.Edit {
+		if opts.Auth == nil {
+			return nil, errors.New("handler: Edit needs Auth")
+		}
+		if _, ok := opts.Root.(WriteFS); !ok {
+			return nil, errors.New("handler: Edit needs a writable Root")
+		}
+	}
+	if opts.Archives {
+		opts.Root = newArchiveFS(opts.Root)
+	}
+	for _, taxonomy := range opts.Taxonomies {
+		if taxonomy == "" || strings.ContainsAny(taxonomy, "/.") {
+			return nil, fmt.Errorf("handler: bad taxonomy %q", taxonomy)
+		}
+	}
+	if opts.Blog != nil {
+		blog := *opts.Blog
+		if blog.Dir = path.Clean(strings.Trim(blog.Dir, "/")); !fs.ValidPath(blog.Dir) {
+			return nil, fmt.Errorf("handler: bad Blog directory %q", opts.Blog.Dir)
+		}
+		opts.Blog = &blog
+		// tags of posts are listed by the taxonomy
+		if !hasString(opts.Taxonomies, "tags") {
+			opts.Taxonomies = append(append([]string(nil), opts.Taxonomies...), "tags")
+		}
+	}
+
+	h := &handler{Options: opts, editMu: new(sync.Mutex), reload: newReloader(), stop: make(chan struct{}), stopOnce: new(sync.Once)}
+	var err error
+	if opts.DAV {
+		if h.dav, err = h.newDAV(); err != nil {
+			return nil, err
+		}
+	}
+	if h.header, err = parseTemplate("header", opts.Header); err != nil {
+		return nil, err
+	}
+	if h.footer, err = parseTemplate("footer", opts.Footer); err != nil {
+		return nil, err
+	}
+	if opts.Backlinks {
+		h.links = h.buildLinks()
+	}
+	if len(opts.Taxonomies) > 0 {
+		h.taxonomy = h.buildTerms()
+	}
+	if opts.BrokenLinks {
+		h.notFound = newNotFoundLog()
+	}
+	if len(opts.Diagrams) > 0 {
+		h.diagramCache = newDiagramCache()
+	}
+	if opts.Nav != "" {
+		h.navCache = new(navCache)
+	}
+	if opts.Blog != nil {
+		h.blogCache = new(blogCache)
+	}
+	if opts.Git != "" {
+		h.commitCache = &commitCache{commits: make(map[string]cachedCommit)}
+	}
+	if opts.Wiki == nil && len(opts.Diagrams) == 0 {
+		h.renderCache = newRenderCache()
+	}
+	if opts.Watch > 0 {
+		go h.watch(opts.Watch, h.snapshot(), h.stop)
+	}
+	return h, nil
+}
+
+// CleanBasePath returns a base path like "/docs", or "" for "/"
+func CleanBasePath(s string) string {
+	s = strings.Trim(s, "/")
+	if s == "" {
+		return ""
+	}
+	return path.Clean("/" + s)
+}
+
+// fileName returns the name in Root of the file served for url path p.
+// paths ending in '/' are the Index file, or with GenerateIndex a directory to list.
+func (h *handler) fileName(p string) (name string, generated bool) {
+	if h.GenerateIndex && strings.HasSuffix(p, "/") {
+		return path.Clean("." + p), true
+	}
+
+	// name is relative to root
+	name = p[1:] // remove slash prefix
+	if name == "" {
+		name = h.Index
+	}
+
+	// '/' suffix, add index page
+	if strings.HasSuffix(name, "/") {
+		name += h.Index
+	}
+	return name, false
+}
+
+// preferMarkdown returns the .md file of the same name as a .html file, if it exists
+func (h *handler) preferMarkdown(name string) string {
+	if strings.HasSuffix(name, ".html") {
+		trymd := strings.TrimSuffix(name, ".html") + ".md"
+		if _, err := fs.Stat(h.Root, trymd); err == nil {
+			return trymd
+		}
+	}
+	return name
+}
+
+// generate kind-of-unique string
+func rfid() string {
+	return fmt.Sprintf("request-%04X", rand.Intn(0xFFFF))
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	logger := h.Logger
+
+	// deny requests containing '..'
+	if strings.Contains(r.URL.Path, "..") {
+		logger.Println("bad path:", r.RemoteAddr, r.Method, r.URL.Path, r.UserAgent())
+		http.NotFound(w, r)
+		return
+	}
+
+	// webdav has methods of its own
+	if h.DAV && (r.URL.Path == davPrefix || strings.HasPrefix(r.URL.Path, davPrefix+"/")) {
+		h.serveDAV(w, r)
+		return
+	}
+
+	// remember 404 responses, and the pages linking to them
+	if h.BrokenLinks {
+		if r.URL.Path == brokenLinksPath {
+			h.serveBrokenLinks(w, r)
+			return
+		}
+		sw := &statusWriter{ResponseWriter: w}
+		defer func() {
+			if sw.code == http.StatusNotFound {
+				h.notFound.add(h.BasePath+r.URL.Path, r.Referer())
+			}
+		}()
+		w = sw
+	}
+
+	// all we want is GET, and POST to save edits
+	if r.Method != "GET" && !(h.Edit && r.Method == "POST") {
+		logger.Println("bad method:", r.RemoteAddr, r.Method, r.URL.Path, r.UserAgent())
+		http.NotFound(w, r)
+		return
+	}
+
+	// start timing
+	t1 := time.Now()
+
+	// Add Server header
+	if h.Server != "" {
+		w.Header().Add("Server", h.Server)
+	}
+
+	// Prevent page from being displayed in an iframe
+	w.Header().Add("X-Frame-Options", "DENY")
+
+	// generate unique request id
+	requestid := rfid()
+
+	// log how long this takes
+	defer func(t func() time.Time) {
+		logger.Println(requestid, "closed after", t().Sub(t1))
+	}(time.Now)
+
+	if h.Syntax && r.URL.Path == "/gh.css" {
+		b, err := Asset("static/gh.css")
+		if err == nil {
+			w.Header().Add("Content-Type", "text/css")
+			w.Write(b)
+			return
+		}
+	}
+
+	if h.Backlinks && r.URL.Path == linksPath {
+		h.serveLinks(w, r)
+		return
+	}
+
+	if h.LiveReload && r.URL.Path == reloadPath {
+		h.serveReload(w, r)
+		return
+	}
+
+	// serve another revision, for requests like /README.md?ref=v1.0.
+	// without Refs the query is left alone, like the ?ref= of tracking links
+	if ref := r.URL.Query().Get("ref"); ref != "" && h.Refs != nil {
+		root, err := h.rootFor(ref)
+		if err != nil {
+			logger.Println(requestid, "error:", err)
+			http.NotFound(w, r)
+			return
+		}
+		logger.Println(requestid, "ref:", ref)
+		rh := *h
+		rh.Root = root
+		rh.renderCache = nil
+		if h.navCache != nil {
+			rh.navCache = new(navCache)
+		}
+		if h.blogCache != nil {
+			rh.blogCache = new(blogCache)
+		}
+		h = &rh
+	}
+
+	// listing pages and feeds of blog posts
+	if h.Blog != nil && h.serveBlog(w, r) {
+		logger.Println(requestid, "blog:", r.URL.Path)
+		return
+	}
+
+	// listing pages of taxonomy terms, like /tags/go/
+	if h.taxonomy != nil && h.serveTaxonomy(w, r) {
+		logger.Println(requestid, "taxonomy:", r.URL.Path)
+		return
+	}
+
+	// paths ending in '/' are the index page, or a generated index
+	name, generated := h.fileName(r.URL.Path)
+	if generated {
+		logger.Println(requestid, "generated index:", name)
+		h.serveIndex(w, r, name)
+		return
+	}
+
+	// log now that we have filename
+	logger.Println(requestid, r.RemoteAddr, r.Method, r.URL.Path, "->", name)
+
+	// names in a fs.FS are unrooted, slash separated, and never contain '..'
+	name = path.Clean(name)
+	if !fs.ValidPath(name) {
+		logger.Println(requestid, "bad path:", name)
+		http.NotFound(w, r)
+		return
+	}
+
+	// .html suffix, but .md exists. choose to serve .md over .html
+	if trymd := h.preferMarkdown(name); trymd != name {
+		logger.Println(requestid, name, "->", trymd)
+		name = trymd
+	}
+
+	// editor, for files that may not exist yet
+	if h.Edit && r.Method == "POST" || h.Edit && editQuery(r) {
+		logger.Println(requestid, "edit:", name)
+		h.serveEdit(w, r, name)
+		return
+	}
+
+	// check if exists, or give 404
+	f, err := h.Root.Open(name)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			logger.Println(requestid, "404", name)
+			http.NotFound(w, r)
+			return
+		}
+
+		// probably permissions
+		logger.Println(requestid, "error opening file:", err, name)
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+
+	// check if symlink ( to avoid /proc/self/root style attacks )
+	if !h.fileisgood(name) {
+		logger.Printf("%s error: %q is symlink. serving 404", requestid, name)
+		http.NotFound(w, r)
+		return
+	}
+
+	stat, err := f.Stat()
+	if err != nil {
+		logger.Printf("%s error reading file: %q", requestid, name)
+		http.NotFound(w, r)
+		return
+	}
+
+	// directory without trailing slash
+	if stat.IsDir() {
+		if strings.HasSuffix(r.URL.Path, "/") {
+			logger.Println(requestid, "404 directory", name)
+			http.NotFound(w, r)
+			return
+		}
+		target := h.BasePath + r.URL.Path + "/"
+		if r.URL.RawQuery != "" {
+			target += "?" + r.URL.RawQuery
+		}
+		logger.Println(requestid, "redirect:", target)
+		http.Redirect(w, r, target, http.StatusMovedPermanently)
+		return
+	}
+
+	// git history and diff views of the file
+	if h.Git != "" {
+		query := r.URL.Query()
+		if _, ok := query["history"]; ok {
+			logger.Println(requestid, "history:", name)
+			h.serveHistory(w, r, name)
+			return
+		}
+		if rev := query.Get("diff"); rev != "" {
+			logger.Println(requestid, "diff:", name, rev)
+			h.serveDiff(w, r, name, rev)
+			return
+		}
+	}
+
+	// static files are streamed
+	if !strings.HasSuffix(name, ".html") && !strings.HasSuffix(name, ".md") {
+		if content, ok := f.(io.ReadSeeker); ok {
+			logger.Printf("%s serving file: %s", requestid, name)
+			http.ServeContent(w, r, name, stat.ModTime(), content)
+			return
+		}
+	}
+
+	// read bytes (for detecting content type )
+	b, err := ioutil.ReadAll(f)
+	if err != nil {
+		logger.Printf("%s error reading file: %q", requestid, name)
+		http.NotFound(w, r)
+		return
+	}
+
+	// detect content type and encoding
+	ct := http.DetectContentType(b)
+
+	// serve raw html if exists
+	if strings.HasSuffix(name, ".html") && strings.HasPrefix(ct, "text/html") {
+		logger.Println(requestid, "serving raw html:", name)
+		w.Header().Add("Content-Type", "text/html")
+		w.Write(b)
+		return
+	}
+
+	// probably markdown
+	if strings.HasSuffix(name, ".md") && strings.HasPrefix(ct, "text/plain") {
+		if h.Raw && strings.Contains(r.URL.RawQuery, "raw") {
+			logger.Println(requestid, "raw markdown request:", name)
+			w.Write(b)
+			return
+		}
+		logger.Println(requestid, "serving markdown:", name)
+
+		doc := h.renderFile(name, b)
+		if doc.HTML == nil {
+			w.WriteHeader(200)
+			return
+		}
+		h.serveDocument(w, r, name, doc, http.StatusOK)
+		return
+	}
+
+	// fallthrough with http.ServeContent
+	logger.Printf("%s serving %s file: %s", requestid, ct, name)
+
+	http.ServeContent(w, r, name, stat.ModTime(), bytes.NewReader(b))
+}
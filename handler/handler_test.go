@@ -0,0 +1,300 @@
+package handler
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// docs directory of the markdownd command, with trailing slash
+func docsDir(t *testing.T) string {
+	dir, err := filepath.Abs("../docs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir + string(os.PathSeparator)
+}
+
+// newHandler serves the docs directory with opts
+func newHandler(t *testing.T, opts Options) http.Handler {
+	if opts.Root == nil {
+		opts.Root = DirFS(docsDir(t))
+	}
+	if opts.Index == "" {
+		opts.Index = "index.md"
+	}
+	h, err := New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { h.(io.Closer).Close() })
+	return h
+}
+
+// tempDocs returns a temporary directory holding files, removed after the test
+func tempDocs(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "markdownd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	writeDocs(t, dir, files)
+	return dir
+}
+
+// writeDocs writes files, named by slash separated paths, in dir
+func writeDocs(t *testing.T, dir string, files map[string]string) {
+	for name, body := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(body), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestFileIsGood(t *testing.T) {
+	dir := docsDir(t)
+	req := "index.md" // index.md exists
+	if !fileisgood(DirFS(dir), req) {
+		// docs is a normal directory, not a symlink. should never be false
+		t.Logf("%s should be good, got bad", req)
+		t.FailNow()
+	}
+}
+
+func TestRefuseSymlinks(t *testing.T) {
+	dir := docsDir(t)
+	os.Remove(dir + "index.link")
+	err := os.Symlink(dir+"index.md", dir+"index.link")
+	defer os.Remove(dir + "index.link")
+	if err != nil {
+		t.Log("Error creating symlink:", err)
+		t.FailNow()
+	}
+	req := "index.link"
+	if fileisgood(DirFS(dir), req) {
+		// index.link is a symlink. should never be true
+		t.Logf("%s should be good, got bad", req)
+		t.FailNow()
+	}
+
+}
+
+func TestRefuseDotDots(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/../main.go", nil)
+	w := httptest.NewRecorder()
+	h := newHandler(t, Options{})
+	h.ServeHTTP(w, req)
+	resp := w.Result()
+	body, _ := ioutil.ReadAll(resp.Body)
+
+	if resp.StatusCode != http.StatusNotFound {
+		t.Log("Expected 404, got:", resp.StatusCode)
+		t.FailNow()
+	}
+
+	if len(body) != 19 {
+		t.Log("Expected 404 body, got:", len(body), string(body))
+		t.FailNow()
+	}
+
+	if string(body) != "404 page not found\n" {
+		t.Logf("Expected %q, got: %q", "404 page not found\n", string(body))
+		t.FailNow()
+	}
+}
+
+func TestHTMLHeaderFooter(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/", nil)
+	w := httptest.NewRecorder()
+	h := newHandler(t, Options{
+		Header: []byte("001"),
+		Footer: []byte("002"),
+	})
+	h.ServeHTTP(w, req)
+	resp := w.Result()
+	body, _ := ioutil.ReadAll(resp.Body)
+
+	if resp.StatusCode != 200 {
+		t.Log("Expected 200, got:", resp.StatusCode)
+		t.FailNow()
+	}
+
+	bodystr := string(body)
+
+	if !strings.HasPrefix(bodystr, "001") {
+		t.Log("Expected prefix of '001'")
+		t.Fail()
+	}
+
+	if !strings.HasSuffix(bodystr, "002") {
+		t.Log("Expected suffix of '002'")
+		t.Fail()
+	}
+}
+
+func sendRequest(t *testing.T, req *http.Request) *http.Response {
+	w := httptest.NewRecorder()
+	h := newHandler(t, Options{})
+	h.ServeHTTP(w, req)
+	resp := w.Result()
+
+	return resp
+
+}
+
+func TestBadMethods(t *testing.T) {
+	methods := []string{"POST", "PUT", "DELETE", "HEAD",
+		"OPTIONS", "TRACE", "CONNECT", "DUMMY"}
+	for _, method := range methods {
+		req, _ := http.NewRequest(method, "/", nil)
+		resp := sendRequest(t, req)
+		if resp.StatusCode != 404 {
+			t.Log("Expected 404, got:", resp.StatusCode)
+			t.FailNow()
+		}
+
+	}
+}
+
+func TestSanitizePolicies(t *testing.T) {
+	in := []byte("hello <script>alert(1)</script><details><summary>more</summary>hidden</details>\n")
+
+	for _, tc := range []struct {
+		policy  string
+		want    []string
+		notwant []string
+	}{
+		{"ugc", []string{"hello", "<details>"}, []string{"<script>"}},
+		{"strict", []string{"hello"}, []string{"<script>", "<details>"}},
+		{"none", []string{"<script>", "<details>"}, nil},
+	} {
+		s, err := NewSanitizer(tc.policy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, isPlain := range []bool{false, true} {
+			h := newHandler(t, Options{Sanitizer: s, Plain: isPlain}).(*handler)
+			out := string(h.markdown2html(in))
+			for _, want := range tc.want {
+				if !strings.Contains(out, want) {
+					t.Errorf("%s (plain=%v): expected %q in %q", tc.policy, isPlain, want, out)
+				}
+			}
+			for _, notwant := range tc.notwant {
+				if strings.Contains(out, notwant) {
+					t.Errorf("%s (plain=%v): did not expect %q in %q", tc.policy, isPlain, notwant, out)
+				}
+			}
+		}
+	}
+}
+
+func TestSanitizeAllowlist(t *testing.T) {
+	f, err := ioutil.TempFile("", "allowlist")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("# trusted embeds\niframe src=www.youtube-nocookie.com width\n")
+	f.Close()
+
+	s, err := NewSanitizer(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	good := `<iframe src="https://www.youtube-nocookie.com/embed/x" width="500"></iframe>`
+	bad := `<iframe src="https://evil.example.com/"></iframe>`
+	if out := string(s.sanitize([]byte(good))); !strings.Contains(out, "youtube-nocookie") {
+		t.Errorf("expected allowlisted iframe, got %q", out)
+	}
+	if out := string(s.sanitize([]byte(bad))); strings.Contains(out, "evil") {
+		t.Errorf("expected iframe src to be dropped, got %q", out)
+	}
+}
+
+func TestBasePath(t *testing.T) {
+	h := newHandler(t, Options{
+		BasePath: "/docs/",
+		Header:   []byte(`<link href="{{.Base}}/gh.css">`),
+	})
+	req, _ := http.NewRequest("GET", "/docs/index.md", nil)
+	w := httptest.NewRecorder()
+	http.StripPrefix("/docs", h).ServeHTTP(w, req)
+	if w.Code != 200 {
+		t.Fatal("Expected 200, got:", w.Code)
+	}
+	if !strings.HasPrefix(w.Body.String(), `<link href="/docs/gh.css">`) {
+		t.Errorf("Expected prefixed theme link, got: %q", w.Body.String()[:40])
+	}
+
+	h = newHandler(t, Options{BasePath: "/docs/", GenerateIndex: true})
+	req, _ = http.NewRequest("GET", "/docs/", nil)
+	w = httptest.NewRecorder()
+	http.StripPrefix("/docs", h).ServeHTTP(w, req)
+	if !strings.Contains(w.Body.String(), `<a href="/docs/index.md">`) {
+		t.Errorf("Expected prefixed index links, got: %q", w.Body.String())
+	}
+}
+
+func TestRefuseSymlinkedDirectory(t *testing.T) {
+	dir := docsDir(t)
+	os.Remove(dir + "linkdir")
+	if err := os.Symlink(dir, dir+"linkdir"); err != nil {
+		t.Fatal("Error creating symlink:", err)
+	}
+	defer os.Remove(dir + "linkdir")
+
+	if fileisgood(DirFS(dir), "linkdir/index.md") {
+		t.Error("linkdir/index.md is inside a symlinked directory, should be bad")
+	}
+	req, _ := http.NewRequest("GET", "/linkdir/index.md", nil)
+	if resp := sendRequest(t, req); resp.StatusCode != http.StatusNotFound {
+		t.Error("Expected 404, got:", resp.StatusCode)
+	}
+
+	// unless following symlinks
+	w := httptest.NewRecorder()
+	newHandler(t, Options{Symlinks: true}).ServeHTTP(w, req)
+	if w.Code != 200 {
+		t.Error("Expected 200 following symlinks, got:", w.Code)
+	}
+}
+
+func TestServeFS(t *testing.T) {
+	h := newHandler(t, Options{
+		Root: fstest.MapFS{
+			"index.md":       {Data: []byte("# hello\n")},
+			"sub/page.md":    {Data: []byte("sub page\n")},
+			"sub/robots.txt": {Data: []byte("User-agent: *\n")},
+		},
+		Raw: true,
+	})
+	for _, tc := range []struct {
+		path, want string
+		code       int
+	}{
+		{"/", "hello", 200},
+		{"/sub/page.html", "sub page", 200},
+		{"/sub/robots.txt", "User-agent", 200},
+		{"/sub/page.md?raw", "sub page", 200},
+		{"/sub", "", http.StatusMovedPermanently},
+		{"/nope.md", "404", 404},
+	} {
+		req, _ := http.NewRequest("GET", tc.path, nil)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != tc.code || !strings.Contains(w.Body.String(), tc.want) {
+			t.Errorf("%s: expected %d %q, got %d %q", tc.path, tc.code, tc.want, w.Code, w.Body.String())
+		}
+	}
+}
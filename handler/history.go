@@ -0,0 +1,206 @@
+package handler
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Commit is the git metadata of a page, available to templates as .Git
+type Commit struct {
+	Hash    string
+	Author  string
+	Email   string
+	Date    time.Time
+	Subject string
+}
+
+// Short returns the abbreviated commit hash
+func (c *Commit) Short() string {
+	if len(c.Hash) > 7 {
+		return c.Hash[:7]
+	}
+	return c.Hash
+}
+
+// one commit per line, fields separated by NUL
+const commitFormat = "--format=%H%x00%an%x00%ae%x00%ct%x00%s"
+
+// gitLog lists the commits of rev that touched name, newest first.
+// max limits the number of commits, if not zero.
+func gitLog(dir, rev, name string, max int) ([]*Commit, error) {
+	args := []string{"log", commitFormat}
+	if max > 0 {
+		args = append(args, "-n", strconv.Itoa(max))
+	} else {
+		args = append(args, "--follow")
+	}
+	args = append(args, rev, "--", literalPath(name))
+	out, err := git(dir, args...)
+	if err != nil {
+		return nil, err
+	}
+	var commits []*Commit
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Split(line, "\x00")
+		if len(fields) != 5 {
+			continue
+		}
+		seconds, err := strconv.ParseInt(fields[3], 10, 64)
+		if err != nil {
+			continue
+		}
+		commits = append(commits, &Commit{
+			Hash:    fields[0],
+			Author:  fields[1],
+			Email:   fields[2],
+			Date:    time.Unix(seconds, 0),
+			Subject: fields[4],
+		})
+	}
+	return commits, nil
+}
+
+// literalPath is a pathspec matching only name, without glob magic
+func literalPath(name string) string {
+	return ":(literal)" + name
+}
+
+// gitRev is the revision being served, HEAD for the work tree
+func (h *handler) gitRev() string {
+	if g, ok := h.Root.(*gitFS); ok {
+		return g.commit
+	}
+	return "HEAD"
+}
+
+// last commits of pages are kept until their file changes, or for commitTTL,
+// which is how late commits made outside of the editor show
+const (
+	commitTTL        = time.Minute
+	maxCachedCommits = 1024
+)
+
+// commitCache keeps the last commit of pages, by revision and name
+type commitCache struct {
+	mu      sync.Mutex
+	commits map[string]cachedCommit
+}
+
+type cachedCommit struct {
+	commit *Commit // nil when not committed
+	state  fileState
+	until  time.Time
+}
+
+// forget drops the commits of name, which is a file or a directory
+func (c *commitCache) forget(name string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key := range c.commits {
+		p := key[strings.IndexByte(key, 0)+1:]
+		if name == "." || p == name || strings.HasPrefix(p, name+"/") {
+			delete(c.commits, key)
+		}
+	}
+}
+
+// lastCommit returns the last commit that touched name, or nil
+func (h *handler) lastCommit(name string) *Commit {
+	if h.Git == "" {
+		return nil
+	}
+	rev := h.gitRev()
+	key, state, now := rev+"\x00"+name, statFile(h.Root, name), time.Now()
+	c := h.commitCache
+	c.mu.Lock()
+	cached, ok := c.commits[key]
+	c.mu.Unlock()
+	if ok && cached.state == state && now.Before(cached.until) {
+		return cached.commit
+	}
+
+	var commit *Commit
+	if commits, err := gitLog(h.Git, rev, name, 1); err == nil && len(commits) > 0 {
+		commit = commits[0]
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.commits[key]; !ok && len(c.commits) >= maxCachedCommits {
+		for k := range c.commits {
+			delete(c.commits, k)
+			break
+		}
+	}
+	c.commits[key] = cachedCommit{commit: commit, state: state, until: now.Add(commitTTL)}
+	return commit
+}
+
+var historyTemplate = template.Must(template.New("history").Parse(`<h1>History of {{.Name}}</h1>
+<ul class="history">
+{{range .Commits}}<li><code>{{if $.Refs}}<a href="{{$.Path}}?ref={{.Hash}}">{{.Short}}</a>{{else}}{{.Short}}{{end}}</code>
+{{.Date.Format "2006-01-02 15:04"}} {{.Author}}: {{.Subject}}
+(<a href="{{$.Path}}?diff={{.Hash}}{{with $.Ref}}&ref={{.}}{{end}}">changes since</a>)</li>
+{{else}}<li>not committed</li>
+{{end}}</ul>
+`))
+
+// serveHistory writes the list of commits that touched name
+func (h *handler) serveHistory(w http.ResponseWriter, r *http.Request, name string) {
+	commits, err := gitLog(h.Git, h.gitRev(), name, 0)
+	if err != nil {
+		h.Logger.Println("error reading history:", err)
+		http.NotFound(w, r)
+		return
+	}
+	var buf bytes.Buffer
+	err = historyTemplate.Execute(&buf, struct {
+		Name, Path, Ref string
+		Refs            bool
+		Commits         []*Commit
+	}{name, h.BasePath + r.URL.Path, r.URL.Query().Get("ref"), h.Refs != nil, commits})
+	if err != nil {
+		h.Logger.Println("error executing history template:", err)
+		http.NotFound(w, r)
+		return
+	}
+	h.servePage(w, r, name, buf.Bytes())
+}
+
+// serveDiff writes the changes to name since revision old, highlighted like a diff code block
+func (h *handler) serveDiff(w http.ResponseWriter, r *http.Request, name, old string) {
+	from, err := resolveRef(h.Git, old)
+	if err != nil {
+		h.Logger.Println("error:", err)
+		http.NotFound(w, r)
+		return
+	}
+	args := []string{"diff", "--no-color", "--no-ext-diff", from}
+	if rev := h.gitRev(); rev != "HEAD" {
+		args = append(args, rev)
+	}
+	diff, err := git(h.Git, append(args, "--", literalPath(name))...)
+	if err != nil {
+		h.Logger.Println("error reading diff:", err)
+		http.NotFound(w, r)
+		return
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "<h1>Changes to %s since <code>%s</code></h1>\n",
+		template.HTMLEscapeString(name), template.HTMLEscapeString(old))
+	if len(diff) == 0 {
+		buf.WriteString("<p>no changes</p>\n")
+	} else {
+		new(gfmRenderer).BlockCode(&buf, diff, "diff")
+	}
+	h.servePage(w, r, name, buf.Bytes())
+}
@@ -0,0 +1,267 @@
+package handler
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html"
+	"io/fs"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// maxIncludeDepth is how deep included files can include others
+const maxIncludeDepth = 16
+
+// limits of the includes of one page, where a file can include another many times over
+const (
+	maxIncludes     = 256
+	maxIncludedSize = 4 << 20
+)
+
+// includeBudget counts the files and bytes included in one page so far
+type includeBudget struct {
+	files, size int
+}
+
+// directives like {{< include "shared/warning.md" >}} and {{< snippet "main.go" region="setup" >}}
+var includeRegexp = regexp.MustCompile(`\{\{<\s*(include|snippet)\s+((?:[^>]|>[^}])*?)\s*>\}\}`)
+
+// arguments of a directive: "quoted", bare, or key="value"
+var argRegexp = regexp.MustCompile(`(?:([\w-]+)=)?(?:"((?:[^"\\]|\\.)*)"|(\S+))`)
+
+// region markers in snippet sources, like "// ANCHOR: setup" (mdBook) or "// #region setup"
+var (
+	regionStartRegexp = regexp.MustCompile(`(?:#region|ANCHOR:)\s*([\w.-]+)`)
+	regionEndRegexp   = regexp.MustCompile(`(?:#endregion|ANCHOR_END:)\s*([\w.-]*)`)
+)
+
+// parseArgs returns the positional and key="value" arguments of a directive
+func parseArgs(s string) (args []string, named map[string]string) {
+	named = make(map[string]string)
+	for _, m := range argRegexp.FindAllStringSubmatch(s, -1) {
+		value := m[3]
+		if value == "" {
+			value = m[2]
+			if v, err := strconv.Unquote(`"` + m[2] + `"`); err == nil {
+				value = v
+			}
+		}
+		if m[1] != "" {
+			named[m[1]] = value
+		} else {
+			args = append(args, value)
+		}
+	}
+	return args, named
+}
+
+// includes expands include and snippet directives in the markdown of page name, outside of code.
+// stack is the pages including this one, budget what the page included so far,
+// and every file read is added to deps.
+func (h *handler) includes(name string, src []byte, ph *placeholders, stack []string, budget *includeBudget, deps *[]string) []byte {
+	return mapText(src, func(text []byte) []byte {
+		return includeRegexp.ReplaceAllFunc(text, func(directive []byte) []byte {
+			m := includeRegexp.FindSubmatch(directive)
+			args, named := parseArgs(string(m[2]))
+			out, err := h.include(name, string(m[1]), args, named, ph, stack, budget, deps)
+			if err != nil {
+				h.Logger.Printf("%s: error in %s: %v", name, directive, err)
+				return append(append([]byte("\n"), ph.addBlock([]byte(fmt.Sprintf(`<p class="include-error">could not %s: %s</p>`,
+					html.EscapeString(string(m[1])), html.EscapeString(err.Error()))))...), '\n')
+			}
+			return out
+		})
+	})
+}
+
+// include returns the markdown of one directive in page name
+func (h *handler) include(name, kind string, args []string, named map[string]string, ph *placeholders, stack []string, budget *includeBudget, deps *[]string) ([]byte, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("want one file name, got %d", len(args))
+	}
+	target, err := h.includeName(name, args[0])
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", args[0], err)
+	}
+	*deps = append(*deps, target)
+	if err := h.checkInclude(target); err != nil {
+		return nil, fmt.Errorf("%s: %v", args[0], err)
+	}
+	if budget.files++; budget.files > maxIncludes {
+		return nil, fmt.Errorf("%s: more than %d includes in the page", args[0], maxIncludes)
+	}
+	b, err := fs.ReadFile(h.Root, target)
+	if err != nil {
+		var pe *fs.PathError
+		if errors.As(err, &pe) {
+			err = pe.Err
+		}
+		return nil, fmt.Errorf("%s: %v", args[0], err)
+	}
+	if budget.size += len(b); budget.size > maxIncludedSize {
+		return nil, fmt.Errorf("%s: more than %d bytes included in the page", args[0], maxIncludedSize)
+	}
+
+	if kind == "snippet" {
+		return snippet(target, b, named)
+	}
+
+	for _, p := range append(stack, name) {
+		if p == target {
+			return nil, fmt.Errorf("include cycle: %s -> %s", strings.Join(append(stack, name), " -> "), target)
+		}
+	}
+	if len(stack) >= maxIncludeDepth {
+		return nil, errors.New("includes nested too deep")
+	}
+	_, b = frontMatter(b)
+	b = h.includes(target, b, ph, append(stack, name), budget, deps)
+	return bytes.TrimRight(b, "\n"), nil
+}
+
+// includeName returns the file in Root for a file name in a directive of page name,
+// relative to the page, or to Root when it starts with '/'. it may not exist.
+func (h *handler) includeName(name, file string) (string, error) {
+	target := path.Join(path.Dir(name), file)
+	if strings.HasPrefix(file, "/") {
+		target = path.Clean(file[1:])
+	}
+	if !fs.ValidPath(target) || target == "." {
+		return "", errors.New("bad path")
+	}
+	return target, nil
+}
+
+// checkInclude returns an error if file target in Root would not be served
+func (h *handler) checkInclude(target string) error {
+	if _, err := fs.Stat(h.Root, target); err != nil {
+		return errors.New("not found")
+	}
+	if !h.visible(target) {
+		return errors.New("refused (symlink or dotfile)")
+	}
+	return nil
+}
+
+// snippet returns a fenced code block of the source in file b, or of its lines="10-20"
+// or region="name", in lang="go" or the language of its extension
+func snippet(name string, b []byte, named map[string]string) ([]byte, error) {
+	lines := strings.SplitAfter(strings.TrimRight(string(b), "\n"), "\n")
+	var err error
+	if region, ok := named["region"]; ok {
+		if lines, err = snippetRegion(lines, region); err != nil {
+			return nil, err
+		}
+	}
+	if span, ok := named["lines"]; ok {
+		if lines, err = snippetLines(lines, span); err != nil {
+			return nil, err
+		}
+	}
+	code := strings.TrimRight(dedent(lines), "\n")
+
+	// a fence longer than any in the code
+	fence := 3
+	for _, line := range strings.Split(code, "\n") {
+		if n := backticks([]byte(strings.TrimLeft(line, " \t"))); n >= fence {
+			fence = n + 1
+		}
+	}
+	lang, ok := named["lang"]
+	if !ok {
+		lang = strings.TrimPrefix(path.Ext(name), ".")
+	}
+	f := strings.Repeat("`", fence)
+	return []byte(f + lang + "\n" + code + "\n" + f), nil
+}
+
+// snippetRegion returns the lines between the markers of region, without any marker lines
+func snippetRegion(lines []string, region string) ([]string, error) {
+	var out []string
+	inside, found := false, false
+	depth := 0 // regions opened inside this one
+	for _, line := range lines {
+		if m := regionStartRegexp.FindStringSubmatch(line); m != nil {
+			if !inside && !found && m[1] == region {
+				inside, found = true, true
+			} else if inside {
+				depth++
+			}
+			continue
+		}
+		if m := regionEndRegexp.FindStringSubmatch(line); m != nil {
+			if inside {
+				if m[1] == region || (m[1] == "" && depth == 0) {
+					inside = false
+				} else if depth > 0 {
+					depth--
+				}
+			}
+			continue
+		}
+		if inside {
+			out = append(out, line)
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("no region %q", region)
+	}
+	return out, nil
+}
+
+// snippetLines returns lines like "10-20", "10-", "-20" or "15", counting from 1
+func snippetLines(lines []string, span string) ([]string, error) {
+	first, last := span, span
+	if i := strings.IndexByte(span, '-'); i != -1 {
+		first, last = span[:i], span[i+1:]
+	}
+	start, end := 1, len(lines)
+	var err1, err2 error
+	if first != "" {
+		start, err1 = strconv.Atoi(first)
+	}
+	if last != "" {
+		end, err2 = strconv.Atoi(last)
+	}
+	if err1 != nil || err2 != nil || start < 1 || end < start {
+		return nil, fmt.Errorf("bad lines %q", span)
+	}
+	if start > len(lines) {
+		return nil, fmt.Errorf("lines %q: only %d lines", span, len(lines))
+	}
+	if end > len(lines) {
+		end = len(lines)
+	}
+	return lines[start-1 : end], nil
+}
+
+// dedent joins lines, without the indentation they all share
+func dedent(lines []string) string {
+	prefix := ""
+	first := true
+	for _, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		indent := line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+		if first {
+			prefix, first = indent, false
+			continue
+		}
+		for !strings.HasPrefix(indent, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	var b strings.Builder
+	for _, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			b.WriteString("\n")
+			continue
+		}
+		b.WriteString(strings.TrimPrefix(line, prefix))
+	}
+	return b.String()
+}
@@ -0,0 +1,126 @@
+package handler
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIncludes(t *testing.T) {
+	files := map[string]string{
+		"shared/warning.md": "---\ntitle: hidden\n---\n**Careful** {{< include \"note.md\" >}}\n",
+		"shared/note.md":    "with *this*.\n",
+		"main.go":           "package main\n\nfunc main() {\n\t// ANCHOR: setup\n\tx := 1\n\t// #region inner\n\ty := x\n\t// #endregion\n\t// ANCHOR_END: setup\n}\n",
+		"loop.md":           "{{< include \"loop2.md\" >}}\n",
+		"loop2.md":          "{{< include \"/loop.md\" >}}\n",
+		".secret.md":        "secret\n",
+		"guide/page.md": strings.Join([]string{
+			`{{< include "../shared/warning.md" >}}`,
+			``,
+			`{{< snippet "/main.go" region="setup" >}}`,
+			``,
+			`{{< snippet "../main.go" lines="3-3" lang="golang" >}}`,
+			``,
+			"`{{< include \"x.md\" >}}`",
+			``,
+			`{{< include "../../etc/passwd" >}}`,
+			``,
+			`{{< include "/.secret.md" >}}`,
+			``,
+			`{{< include "/loop.md" >}}`,
+			``,
+			`{{< snippet "/main.go" region="nope" >}}`,
+		}, "\n"),
+		"symlinked.md": `{{< include "link.md" >}}`,
+	}
+	// every level includes the next ten times
+	for i := 0; i < maxIncludeDepth; i++ {
+		files[fmt.Sprintf("fan/%d.md", i)] = strings.Repeat(fmt.Sprintf("{{< include \"%d.md\" >}}\n\n", i+1), 10)
+	}
+	files[fmt.Sprintf("fan/%d.md", maxIncludeDepth)] = "leaf\n"
+	dir := tempDocs(t, files)
+	if err := os.Symlink(filepath.Join(dir, "shared/note.md"), filepath.Join(dir, "link.md")); err != nil {
+		t.Fatal(err)
+	}
+
+	h := newHandler(t, Options{Root: DirFS(dir), Includes: true, LiveReload: true, Watch: 10 * time.Millisecond})
+	get := func(path string) string {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w.Body.String()
+	}
+
+	body := get("/guide/page.md")
+	for _, want := range []string{
+		"<p><strong>Careful</strong> with <em>this</em>.</p>",
+		`<div class="highlight highlight-go"><pre>x := 1` + "\ny := x\n</pre>",
+		`<div class="highlight highlight-golang"><pre>func main() {`,
+		`<code>{{&lt; include &#34;x.md&#34; &gt;}}</code>`,
+		`<p class="include-error">could not include: ../../etc/passwd: bad path</p>`,
+		`<p class="include-error">could not include: /.secret.md: refused (symlink or dotfile)</p>`,
+		`<p class="include-error">could not include: include cycle: guide/page.md -&gt; loop.md -&gt; loop2.md -&gt; loop.md</p>`,
+		`<p class="include-error">could not snippet: no region &#34;nope&#34;</p>`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected %q in %q", want, body)
+		}
+	}
+	for _, notwant := range []string{"hidden", "ANCHOR", "#region", "package main", "secret\n"} {
+		if strings.Contains(body, notwant) {
+			t.Errorf("did not expect %q in %q", notwant, body)
+		}
+	}
+	if body := get("/symlinked.md"); !strings.Contains(body, "refused (symlink or dotfile)") {
+		t.Errorf("expected symlink to be refused, got %q", body)
+	}
+	body = get("/fan/0.md")
+	if n := strings.Count(body, "<p>leaf</p>"); n == 0 || n > maxIncludes {
+		t.Errorf("expected at most %d includes, got %d", maxIncludes, n)
+	}
+	if !strings.Contains(body, `<p class="include-error">could not include: 2.md: more than 256 includes in the page</p>`) {
+		t.Errorf("expected an include error in %q", body)
+	}
+
+	// off by default
+	off := newHandler(t, Options{Root: DirFS(dir)}).(*handler)
+	if out := string(off.renderPage("guide/page.md", []byte(`{{< include "x.md" >}}`)).HTML); !strings.Contains(out, "{{&lt; include") {
+		t.Errorf("expected no includes, got %q", out)
+	}
+
+	// cached until an included file changes, and pages including it reload
+	rl := h.(*handler).reload
+	ch := rl.subscribe()
+	defer rl.unsubscribe(ch)
+	writeDocs(t, dir, map[string]string{"shared/note.md": "with *that*, changed.\n"})
+	deadline := time.After(5 * time.Second)
+	for seen := map[string]bool{}; !seen["guide/page.md"]; {
+		select {
+		case name := <-ch:
+			seen[name] = true
+		case <-deadline:
+			t.Fatalf("no reload of the including page, got %v", seen)
+		}
+	}
+	if body := get("/guide/page.md"); !strings.Contains(body, "with <em>that</em>, changed.") {
+		t.Errorf("expected changed include in %q", body)
+	}
+}
+
+func TestSnippetLines(t *testing.T) {
+	lines := []string{"a\n", "b\n", "c\n"}
+	for span, want := range map[string]string{"2": "b\n", "2-": "b\nc\n", "-2": "a\nb\n", "1-9": "a\nb\nc\n"} {
+		got, err := snippetLines(lines, span)
+		if err != nil || strings.Join(got, "") != want {
+			t.Errorf("%q: got %q, %v", span, got, err)
+		}
+	}
+	for _, span := range []string{"0", "3-2", "x", "4"} {
+		if _, err := snippetLines(lines, span); err == nil {
+			t.Errorf("%q: expected error", span)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package handler
+
+import (
+	"fmt"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"path"
+)
+
+// serveIndex writes a generated directory listing, with links under the base path
+func (h *handler) serveIndex(w http.ResponseWriter, r *http.Request, dir string) {
+	if !h.fileisgood(dir) {
+		h.Logger.Printf("error: %q is symlink. serving 404", dir)
+		http.NotFound(w, r)
+		return
+	}
+	files, err := fs.ReadDir(h.Root, dir)
+	if err != nil {
+		h.Logger.Println("error reading directory:", err)
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprintf(w, "<pre>\n")
+	for _, f := range files {
+		// no symlinks
+		if !h.Symlinks && f.Type()&fs.ModeSymlink != 0 {
+			continue
+		}
+		name := f.Name()
+		link := url.URL{Path: path.Join(h.BasePath, r.URL.Path, name)}
+		if f.IsDir() {
+			name += "/"
+			link.Path += "/"
+		}
+		fmt.Fprintf(w, "<a href=\"%s\">%s</a>\n", template.HTMLEscapeString(h.withRef(link.String(), r)), template.HTMLEscapeString(name))
+	}
+	fmt.Fprintf(w, "</pre>\n")
+}
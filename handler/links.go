@@ -0,0 +1,180 @@
+package handler
+
+import (
+	"encoding/json"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// url path of the link graph, after the base path
+const linksPath = "/_api/links"
+
+// Link is a page linking to the current page, available to templates as .Backlinks
+type Link struct {
+	Name  string `json:"name"`  // file name in Root, like "guides/start.md"
+	Title string `json:"title"` // first heading, or the file name
+	URL   string `json:"url"`   // link to the page, with the base path
+}
+
+// linkIndex knows which markdown pages link to which
+type linkIndex struct {
+	mu    sync.RWMutex
+	pages map[string]*indexedPage
+}
+
+type indexedPage struct {
+	Title string   `json:"title"`
+	Links []string `json:"links"` // markdown files linked to, which may not exist
+
+	deps []string // files included, or linked to with ref, which may not exist
+}
+
+// buildLinks indexes every markdown page in Root
+func (h *handler) buildLinks() *linkIndex {
+	idx := &linkIndex{pages: make(map[string]*indexedPage)}
+	idx.update(h, ".")
+	return idx
+}
+
+// update reindexes name, which is a page or a directory of pages, or was removed
+func (idx *linkIndex) update(h *handler, name string) {
+	pages := make(map[string]*indexedPage)
+	fs.WalkDir(h.Root, name, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if p != "." && !h.visible(p) {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if d.Type().IsRegular() && strings.HasSuffix(p, ".md") {
+			if b, err := fs.ReadFile(h.Root, p); err == nil {
+				pages[p] = h.indexPage(p, b)
+			}
+		}
+		return nil
+	})
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	for p := range idx.pages {
+		if name == "." || p == name || strings.HasPrefix(p, name+"/") {
+			delete(idx.pages, p)
+		}
+	}
+	for p, page := range pages {
+		idx.pages[p] = page
+	}
+}
+
+// includers returns the pages including name, which is a file or a directory
+func (idx *linkIndex) includers(name string) []string {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	var pages []string
+	for p, page := range idx.pages {
+		for _, dep := range page.deps {
+			if dep == name || strings.HasPrefix(dep, name+"/") {
+				pages = append(pages, p)
+				break
+			}
+		}
+	}
+	return pages
+}
+
+// indexPage finds the title of a page, and the markdown pages it links to
+func (h *handler) indexPage(name string, src []byte) *indexedPage {
+	doc := h.renderPage(name, src)
+	page := &indexedPage{Title: pageTitle(name, src), Links: []string{}, deps: doc.deps}
+	seen := make(map[string]bool)
+	for _, href := range pageLinks(doc.HTML) {
+		if target, ok := h.linkTarget(name, href); ok && !seen[target] && target != name {
+			seen[target] = true
+			page.Links = append(page.Links, target)
+		}
+	}
+	sort.Strings(page.Links)
+	return page
+}
+
+// linkTarget returns the markdown file in Root that href, found in page name, links to
+func (h *handler) linkTarget(name, href string) (string, bool) {
+	u, err := url.Parse(href)
+	if err != nil || u.Scheme != "" || u.Host != "" || u.Path == "" {
+		return "", false
+	}
+	p, err := h.linkPath(name, u)
+	if err != nil {
+		return "", false
+	}
+	if strings.HasSuffix(p, "/") && (h.GenerateIndex || h.Index == "") {
+		return "", false
+	}
+	p, _ = h.fileName(p)
+	target := path.Clean(p)
+	if strings.HasSuffix(target, ".html") {
+		target = strings.TrimSuffix(target, ".html") + ".md"
+	}
+	if !strings.HasSuffix(target, ".md") || !fs.ValidPath(target) {
+		return "", false
+	}
+	return target, true
+}
+
+// pageTitle returns the first heading of a markdown page, or its file name
+func pageTitle(name string, src []byte) string {
+	for _, line := range strings.Split(string(src), "\n") {
+		if strings.HasPrefix(line, "# ") {
+			if title := strings.TrimSpace(strings.Trim(line, "# ")); title != "" {
+				return title
+			}
+		}
+	}
+	return path.Base(name)
+}
+
+// backlinks returns the pages linking to name
+func (h *handler) backlinks(name string) []Link {
+	if h.links == nil {
+		return nil
+	}
+	h.links.mu.RLock()
+	defer h.links.mu.RUnlock()
+	var list []Link
+	for p, page := range h.links.pages {
+		i := sort.SearchStrings(page.Links, name)
+		if i < len(page.Links) && page.Links[i] == name {
+			list = append(list, Link{Name: p, Title: page.Title, URL: h.BasePath + "/" + (&url.URL{Path: p}).String()})
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// serveLinks writes the link graph as json
+func (h *handler) serveLinks(w http.ResponseWriter, r *http.Request) {
+	if h.links == nil {
+		http.NotFound(w, r)
+		return
+	}
+	h.links.mu.RLock()
+	b, err := json.MarshalIndent(struct {
+		Pages map[string]*indexedPage `json:"pages"`
+	}{h.links.pages}, "", "  ")
+	h.links.mu.RUnlock()
+	if err != nil {
+		h.Logger.Println("error encoding links:", err)
+		http.Error(w, "500 internal server error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
@@ -0,0 +1,82 @@
+package handler
+
+import (
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBacklinks(t *testing.T) {
+	dir := tempDocs(t, map[string]string{
+		"b.md":         "# Bee\n",
+		"a.md":         "# Ay\n\n[bee](b.md) and [bee again](b.md#top)\n",
+		"c.md":         "[bee](/docs/b.md), [outside](/other/b.md), [web](https://example.com/b.md)\n",
+		"sub/e.md":     "[bee](../b.html) and [missing](nope.md)\n",
+		".hidden/x.md": "[bee](../b.md)\n",
+	})
+
+	h := newHandler(t, Options{
+		Root:      DirFS(dir),
+		BasePath:  "/docs",
+		Backlinks: true,
+		Watch:     10 * time.Millisecond,
+		Footer:    []byte(`{{range .Backlinks}}<li><a href="{{.URL}}">{{.Title}}</a></li>{{end}}`),
+	})
+	get := func(path string) string {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w.Body.String()
+	}
+
+	body := get("/b.md")
+	for _, want := range []string{
+		`<li><a href="/docs/a.md">Ay</a></li><li><a href="/docs/c.md">c.md</a></li><li><a href="/docs/sub/e.md">e.md</a></li>`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected %q in %q", want, body)
+		}
+	}
+
+	var graph struct {
+		Pages map[string]struct {
+			Title string
+			Links []string
+		}
+	}
+	if err := json.Unmarshal([]byte(get("/_api/links")), &graph); err != nil {
+		t.Fatal(err)
+	}
+	if len(graph.Pages) != 4 || strings.Join(graph.Pages["sub/e.md"].Links, " ") != "b.md sub/nope.md" {
+		t.Errorf("unexpected graph: %+v", graph)
+	}
+
+	// files changed on disk are found by polling
+	writeDocs(t, dir, map[string]string{"f.md": "[bee](b.md)\n"})
+	for i := 0; i < 200 && !strings.Contains(get("/b.md"), "/docs/f.md"); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !strings.Contains(get("/b.md"), "/docs/f.md") {
+		t.Error("expected new page in backlinks")
+	}
+	os.Remove(filepath.Join(dir, "a.md"))
+	for i := 0; i < 200 && strings.Contains(get("/b.md"), "/docs/a.md"); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if strings.Contains(get("/b.md"), "/docs/a.md") {
+		t.Error("expected removed page to leave backlinks")
+	}
+
+	// closing the handler stops polling
+	h.(io.Closer).Close()
+	time.Sleep(20 * time.Millisecond)
+	writeDocs(t, dir, map[string]string{"g.md": "[bee](b.md)\n"})
+	time.Sleep(100 * time.Millisecond)
+	if strings.Contains(get("/b.md"), "/docs/g.md") {
+		t.Error("expected no polling after Close")
+	}
+}
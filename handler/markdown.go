@@ -0,0 +1,117 @@
+package handler
+
+import (
+	"bytes"
+
+	"github.com/russross/blackfriday"
+	"github.com/sourcegraph/syntaxhighlight"
+)
+
+func (h *handler) markdown2html(in []byte) []byte {
+	return h.renderPage("", in).HTML
+}
+
+// document is a rendered markdown page
+type document struct {
+	HTML []byte
+	TOC  []Heading              // headings, for templates
+	Meta map[string]interface{} // front matter
+
+	deps []string // files the page depends on, like included files, for the render cache
+}
+
+// renderPage renders markdown of page name after its front matter, and finds its table of contents.
+// name is "" for markdown that is not in a file.
+func (h *handler) renderPage(name string, in []byte) document {
+	var doc document
+	doc.Meta, in = frontMatter(in)
+	if len(in) == 0 {
+		return doc
+	}
+
+	// generated html is put back after rendering, and sanitized by itself unless it is escaped
+	ph := newPlaceholders()
+	if h.Includes {
+		in = h.includes(name, in, ph, nil, new(includeBudget), &doc.deps)
+	}
+	if h.Shortcodes != nil {
+		in = h.variables(in, doc.Meta)
+		in = h.shortcodes(name, in, doc.Meta, ph, &doc.deps)
+	}
+	if h.Alerts {
+		in = alerts(in, ph, h.Sanitizer)
+	}
+	if len(h.Diagrams) > 0 {
+		in = h.diagrams(in, ph)
+	}
+	if h.Wiki != nil {
+		in = h.wikiLinks(in, ph)
+	}
+	if metaBool(doc.Meta, "math", h.Math) {
+		in = mathFormulas(in, ph)
+	}
+	if h.Emoji {
+		in = emojiShortcodes(in)
+	}
+	token := newPlaceholders().add(nil)
+	in, marker := tocMarker(in, token)
+	out := ph.replace(h.render(in))
+
+	depth := h.TOCDepth
+	if depth <= 0 {
+		depth = defaultTOCDepth
+	}
+	doc.TOC = headings(out, depth)
+	switch {
+	case marker:
+		nav := tocHTML(doc.TOC)
+		out = bytes.ReplaceAll(out, append(append([]byte("<p>"), token...), "</p>"...), nav)
+		out = bytes.ReplaceAll(out, token, nav)
+	case h.TOC && len(doc.TOC) > 0:
+		out = append(tocHTML(doc.TOC), out...)
+	}
+	doc.HTML = out
+	return doc
+}
+
+// render markdown with the gfm or plain renderer, and sanitize it
+func (h *handler) render(in []byte) []byte {
+	// default flags, heading ids are for the table of contents
+	flags, extensions := 0, gfmExtensions
+	if h.Plain {
+		extensions = blackfriday.EXTENSION_AUTO_HEADER_IDS
+	}
+	if h.Extensions != nil {
+		flags, extensions = h.Extensions.htmlFlags, h.Extensions.extensions
+	}
+	if h.Sanitizer.skipHTML {
+		flags |= blackfriday.HTML_SKIP_HTML
+	}
+	if h.Footnotes {
+		flags |= blackfriday.HTML_FOOTNOTE_RETURN_LINKS
+		extensions |= blackfriday.EXTENSION_FOOTNOTES
+	}
+	if h.DefinitionLists {
+		extensions |= blackfriday.EXTENSION_DEFINITION_LISTS
+	}
+	params := blackfriday.HtmlRendererParameters{FootnoteReturnLinkContents: "↩"}
+	if !h.Plain {
+		return h.Sanitizer.sanitize(gfmMarkdown(in, flags, extensions, params))
+	}
+	md := blackfriday.Markdown(
+		in, blackfriday.HtmlRendererWithParameters(
+			// html flags
+			flags,
+			"", "", params),
+		extensions)
+	return h.Sanitizer.sanitize(md)
+}
+
+func (h *handler) highlightSyntaxHTML(in []byte) (out []byte) {
+	out, err := syntaxhighlight.AsHTML(in)
+	if err != nil {
+		h.Logger.Println("error highlighting syntax:", err)
+		return in
+	}
+	return out
+}
@@ -0,0 +1,75 @@
+package handler
+
+import "bytes"
+
+// mathFormulas replaces $$display$$ and $inline$ formulas outside of code
+// with placeholders for their MathML. \$ is a dollar sign.
+//
+// Like pandoc, an inline formula starts with a '$' followed by a non-space,
+// and ends at the next '$' after a non-space that is not followed by a digit,
+// so "$5 and $10" is not a formula.
+func mathFormulas(src []byte, ph *placeholders) []byte {
+	return mapText(src, func(text []byte) []byte {
+		var out bytes.Buffer
+		for i := 0; i < len(text); i++ {
+			switch {
+			case bytes.HasPrefix(text[i:], []byte(`\$`)):
+				out.WriteByte('$')
+				i++
+			case text[i] == '\\' && i+1 < len(text):
+				out.Write(text[i : i+2])
+				i++
+			case text[i] != '$':
+				out.WriteByte(text[i])
+			case bytes.HasPrefix(text[i:], []byte("$$")):
+				end := bytes.Index(text[i+2:], []byte("$$"))
+				if end == -1 || len(bytes.TrimSpace(text[i+2:i+2+end])) == 0 {
+					out.WriteString("$$")
+					i++
+					continue
+				}
+				tex := string(bytes.TrimSpace(text[i+2 : i+2+end]))
+				out.Write(ph.addBlock([]byte(texMathML(tex, true))))
+				i += 2 + end + 1
+			default:
+				end := inlineMathEnd(text, i)
+				if end == -1 {
+					out.WriteByte('$')
+					continue
+				}
+				out.Write(ph.add([]byte(texMathML(string(text[i+1:end]), false))))
+				i = end
+			}
+		}
+		return out.Bytes()
+	})
+}
+
+// inlineMathEnd returns the index of the '$' closing an inline formula opened at start, or -1
+func inlineMathEnd(text []byte, start int) int {
+	if start+1 >= len(text) || isSpace(text[start+1]) {
+		return -1
+	}
+	for j := start + 1; j < len(text); j++ {
+		switch text[j] {
+		case '\\':
+			j++
+		case '\n':
+			// formulas do not span paragraphs
+			rest := text[j+1:]
+			if k := bytes.IndexByte(rest, '\n'); k != -1 && len(bytes.TrimSpace(rest[:k])) == 0 {
+				return -1
+			}
+		case '$':
+			if isSpace(text[j-1]) || j+1 < len(text) && isDigit(text[j+1]) {
+				return -1
+			}
+			return j
+		}
+	}
+	return -1
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
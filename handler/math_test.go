@@ -0,0 +1,102 @@
+package handler
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTeXMathML(t *testing.T) {
+	for _, tc := range []struct{ tex, want string }{
+		{`x^2`, `<msup><mi>x</mi><mrow><mn>2</mn></mrow></msup>`},
+		{`a_{ij}`, `<msub><mi>a</mi><mrow><mrow><mi>i</mi><mi>j</mi></mrow></mrow></msub>`},
+		{`\frac12`, `<mfrac><mn>1</mn><mn>2</mn></mfrac>`},
+		{`\sqrt[3]{x}`, `<mroot><mrow><mi>x</mi></mrow><mrow><mn>3</mn></mrow></mroot>`},
+		{`\sum_{i=1}^n`, `<munderover><mo movablelimits="true">∑</mo>`},
+		{`\alpha \leq \infty`, `<mi>α</mi><mo>≤</mo><mi>∞</mi>`},
+		{`\mathbb{R}^n`, `<msup><mrow><mi>ℝ</mi></mrow>`},
+		{`\left( x \right]`, `<mrow><mo fence="true" stretchy="true">(</mo><mi>x</mi><mo fence="true" stretchy="true">]</mo></mrow>`},
+		{`\begin{cases} 1 & x \\ 0 \end{cases}`, `<mtable columnalign="left left"><mtr><mtd><mn>1</mn></mtd><mtd><mi>x</mi></mtd></mtr><mtr><mtd><mn>0</mn></mtd></mtr></mtable>`},
+		{`\text{a<b}`, `<mtext>a&lt;b</mtext>`},
+		{`\nope{x}`, `<merror><mtext>\nope</mtext></merror>`},
+		{`x}<`, `<mi>x</mi><merror><mtext>}</mtext></merror><mo>&lt;</mo>`},
+	} {
+		if got := texMathML(tc.tex, false); !strings.Contains(got, tc.want) {
+			t.Errorf("%s: expected %q in %q", tc.tex, tc.want, got)
+		}
+	}
+}
+
+func TestTeXDepth(t *testing.T) {
+	for _, tex := range []string{
+		strings.Repeat("{", 3000000),
+		strings.Repeat(`\frac{`, 100000),
+		strings.Repeat(`\left(`, 100000),
+		strings.Repeat(`\begin{matrix}`, 100000),
+	} {
+		got := texMathML(tex, false)
+		if !strings.Contains(got, "<merror><mtext>too deeply nested</mtext></merror>") {
+			t.Errorf("expected an error for %.20s..., got %.200s", tex, got)
+		}
+	}
+	if got := texMathML(strings.Repeat("{", 50)+"x"+strings.Repeat("}", 50), false); strings.Contains(got, "merror") {
+		t.Errorf("expected no error for a nested group, got %s", got)
+	}
+}
+
+func TestMath(t *testing.T) {
+	h := newHandler(t, Options{Math: true}).(*handler)
+	for _, tc := range []struct {
+		src     string
+		want    []string
+		notwant []string
+	}{
+		{"Euler: $e^{i\\pi} = -1$.", []string{"<p>Euler: <math ", "<mi>π</mi>", "</math>.</p>"}, nil},
+		{"$$\n\\frac{a}{b}\n$$\n", []string{`<math xmlns="http://www.w3.org/1998/Math/MathML" display="block">`}, []string{"<p>"}},
+		{"costs $5 and $10", []string{"costs $5 and $10"}, []string{"<math"}},
+		{"a \\$x$ b", []string{"a $x$ b"}, []string{"<math"}},
+		{"code `$x$` and\n\n```\n$$y$$\n```\n", []string{"<code>$x$</code>", "$$y$$"}, []string{"<math"}},
+		{"$a *b*$ is math, $ a$ is not", []string{"<mi>a</mi><mo>∗</mo><mi>b</mi><mo>∗</mo>", "$ a$ is not"}, []string{"<em>"}},
+		{"# Area $r^2$\n", []string{`<h1><a name="area-" class="anchor" href="#area-"`}, []string{"mdph"}},
+		{"---\nmath: false\n---\n$x$\n", []string{"<p>$x$</p>"}, []string{"<math", "math: false"}},
+	} {
+		out := string(h.markdown2html([]byte(tc.src)))
+		for _, want := range tc.want {
+			if !strings.Contains(out, want) {
+				t.Errorf("%q: expected %q in %q", tc.src, want, out)
+			}
+		}
+		for _, notwant := range tc.notwant {
+			if strings.Contains(out, notwant) {
+				t.Errorf("%q: did not expect %q in %q", tc.src, notwant, out)
+			}
+		}
+	}
+
+	// off by default, on with front matter
+	h = newHandler(t, Options{}).(*handler)
+	if out := string(h.markdown2html([]byte("$x$"))); strings.Contains(out, "<math") {
+		t.Errorf("expected no math, got %q", out)
+	}
+	if out := string(h.markdown2html([]byte("---\nmath: true\n---\n$x$"))); !strings.Contains(out, "<math") {
+		t.Errorf("expected math from front matter, got %q", out)
+	}
+}
+
+func TestFrontMatter(t *testing.T) {
+	for _, tc := range []struct {
+		src, body, title string
+	}{
+		{"---\ntitle: Hello\n---\n# Body\n", "# Body\n", "Hello"},
+		{"---\r\ntitle: Hello\r\n...\r\nbody", "body", "Hello"},
+		{"---\ntitle: Hello\n---", "", "Hello"},
+		{"---\n\nnot front matter\n", "---\n\nnot front matter\n", ""},
+		{"# Title\n---\ntitle: x\n---\n", "# Title\n---\ntitle: x\n---\n", ""},
+		{"---\n- a list\n---\n", "---\n- a list\n---\n", ""},
+	} {
+		meta, body := frontMatter([]byte(tc.src))
+		title, _ := meta["title"].(string)
+		if string(body) != tc.body || title != tc.title {
+			t.Errorf("%q: expected %q and title %q, got %q and %q", tc.src, tc.body, tc.title, body, title)
+		}
+	}
+}
@@ -0,0 +1,543 @@
+package handler
+
+import (
+	"html"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// A subset of LaTeX math is converted to MathML, which browsers render without scripts:
+// scripts, fractions, roots, \left \right delimiters, accents, fonts like \mathbb,
+// \text, greek letters and common symbols, and matrix, cases and aligned environments.
+// Unknown commands are shown as errors in the formula.
+
+// texMathML returns the MathML of a LaTeX formula
+func texMathML(tex string, display bool) string {
+	p := &texParser{src: tex}
+	var body strings.Builder
+	for {
+		body.WriteString(p.row())
+		switch {
+		case p.eof():
+		case strings.HasPrefix(p.src[p.pos:], `\\`):
+			// line breaks outside of environments are ignored
+			p.pos += 2
+			continue
+		case p.atCommand("right"), p.atCommand("end"):
+			body.WriteString(texError(`\` + p.command()))
+			continue
+		default:
+			// stray '}' or '&'
+			body.WriteString(texError(p.src[p.pos : p.pos+1]))
+			p.pos++
+			continue
+		}
+		break
+	}
+	var b strings.Builder
+	b.WriteString(`<math xmlns="http://www.w3.org/1998/Math/MathML"`)
+	if display {
+		b.WriteString(` display="block"`)
+	}
+	b.WriteString("><semantics><mrow>")
+	b.WriteString(body.String())
+	b.WriteString(`</mrow><annotation encoding="application/x-tex">`)
+	b.WriteString(html.EscapeString(tex))
+	b.WriteString("</annotation></semantics></math>")
+	return b.String()
+}
+
+type texParser struct {
+	src   string
+	pos   int
+	index bool // in the [n] of \sqrt[n]{x}
+	depth int  // atoms being parsed, see maxTexDepth
+}
+
+// maxTexDepth limits the nesting of groups, arguments and environments,
+// so a formula like {{{{... can not overflow the stack
+const maxTexDepth = 100
+
+func (p *texParser) eof() bool { return p.pos >= len(p.src) }
+
+func (p *texParser) peek() byte {
+	if p.eof() {
+		return 0
+	}
+	return p.src[p.pos]
+}
+
+func (p *texParser) skipSpace() {
+	for !p.eof() && strings.IndexByte(" \t\r\n", p.peek()) != -1 {
+		p.pos++
+	}
+}
+
+// atStop reports whether the parser is at the end of a row
+func (p *texParser) atStop() bool {
+	p.skipSpace()
+	if p.eof() {
+		return true
+	}
+	switch p.peek() {
+	case '}', '&':
+		return true
+	case ']':
+		return p.index
+	}
+	rest := p.src[p.pos:]
+	return strings.HasPrefix(rest, `\\`) || p.atCommand("right") || p.atCommand("end")
+}
+
+// atCommand reports whether the parser is at \name
+func (p *texParser) atCommand(name string) bool {
+	rest := p.src[p.pos:]
+	if !strings.HasPrefix(rest, `\`+name) {
+		return false
+	}
+	rest = rest[len(name)+1:]
+	return rest == "" || !isLetter(rest[0])
+}
+
+func isLetter(c byte) bool { return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' }
+
+func isDigit(c byte) bool { return '0' <= c && c <= '9' }
+
+// command reads a command name after '\'
+func (p *texParser) command() string {
+	p.pos++ // '\'
+	start := p.pos
+	for !p.eof() && isLetter(p.peek()) {
+		p.pos++
+	}
+	if p.pos == start && !p.eof() {
+		_, size := utf8.DecodeRuneInString(p.src[p.pos:])
+		p.pos += size
+	}
+	return p.src[start:p.pos]
+}
+
+// row parses atoms and their scripts, until the end of the row
+func (p *texParser) row() string {
+	var b strings.Builder
+	for !p.atStop() {
+		b.WriteString(p.scripts(p.atom()))
+	}
+	return b.String()
+}
+
+// group parses {...} as a row, or a single atom
+func (p *texParser) arg() string {
+	p.skipSpace()
+	switch {
+	case p.eof():
+		return "<mrow></mrow>"
+	case isDigit(p.peek()):
+		p.pos++
+		return "<mn>" + p.src[p.pos-1:p.pos] + "</mn>"
+	}
+	return p.atom()
+}
+
+// raw returns the text of a {...} argument, unparsed
+func (p *texParser) raw() string {
+	p.skipSpace()
+	if p.peek() != '{' {
+		if p.eof() {
+			return ""
+		}
+		_, size := utf8.DecodeRuneInString(p.src[p.pos:])
+		p.pos += size
+		return p.src[p.pos-size : p.pos]
+	}
+	depth := 0
+	start := p.pos + 1
+	for ; !p.eof(); p.pos++ {
+		switch p.peek() {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				p.pos++
+				return p.src[start : p.pos-1]
+			}
+		}
+	}
+	return p.src[start:]
+}
+
+// scripts parses ^ and _ after base
+func (p *texParser) scripts(base string) string {
+	var sup, sub string
+	for {
+		p.skipSpace()
+		switch p.peek() {
+		case '^':
+			p.pos++
+			sup += p.arg()
+			continue
+		case '_':
+			p.pos++
+			sub += p.arg()
+			continue
+		case '\'':
+			p.pos++
+			sup += "<mo>′</mo>"
+			continue
+		}
+		break
+	}
+	if sup == "" && sub == "" {
+		return base
+	}
+	if sup != "" {
+		sup = "<mrow>" + sup + "</mrow>"
+	}
+	if sub != "" {
+		sub = "<mrow>" + sub + "</mrow>"
+	}
+	// limits above and below big operators, in display formulas
+	under, over, both := "msub", "msup", "msubsup"
+	if strings.Contains(base, `movablelimits="true"`) {
+		under, over, both = "munder", "mover", "munderover"
+	}
+	switch {
+	case sup == "":
+		return "<" + under + ">" + base + sub + "</" + under + ">"
+	case sub == "":
+		return "<" + over + ">" + base + sup + "</" + over + ">"
+	}
+	return "<" + both + ">" + base + sub + sup + "</" + both + ">"
+}
+
+// atom parses a symbol, number, group or command
+func (p *texParser) atom() string {
+	p.depth++
+	defer func() { p.depth-- }()
+	if p.depth > maxTexDepth {
+		// give up on the rest of the formula
+		p.pos = len(p.src)
+		return texError("too deeply nested")
+	}
+	p.skipSpace()
+	c := p.peek()
+	switch {
+	case c == '{':
+		p.pos++
+		outer := p.index
+		p.index = false
+		r := p.row()
+		p.index = outer
+		if p.peek() == '}' {
+			p.pos++
+		}
+		return "<mrow>" + r + "</mrow>"
+	case c == '\\':
+		return p.macro(p.command())
+	case isDigit(c) || c == '.' && p.pos+1 < len(p.src) && isDigit(p.src[p.pos+1]):
+		start := p.pos
+		for !p.eof() && (isDigit(p.peek()) || p.peek() == '.' && p.pos+1 < len(p.src) && isDigit(p.src[p.pos+1])) {
+			p.pos++
+		}
+		return "<mn>" + p.src[start:p.pos] + "</mn>"
+	case isLetter(c):
+		p.pos++
+		return "<mi>" + string(c) + "</mi>"
+	case c == '~':
+		p.pos++
+		return `<mspace width="0.333em"></mspace>`
+	case c == '^' || c == '_':
+		return "<mrow></mrow>"
+	}
+	r, size := utf8.DecodeRuneInString(p.src[p.pos:])
+	p.pos += size
+	switch {
+	case r == '-':
+		return "<mo>−</mo>"
+	case r == '*':
+		return "<mo>∗</mo>"
+	case unicode.IsLetter(r):
+		return "<mi>" + html.EscapeString(string(r)) + "</mi>"
+	case strings.ContainsRune("()[]", r):
+		return `<mo stretchy="false">` + string(r) + "</mo>"
+	}
+	return "<mo>" + html.EscapeString(string(r)) + "</mo>"
+}
+
+// macro parses the arguments of command name, and returns its MathML
+func (p *texParser) macro(name string) string {
+	if s, ok := texIdentifiers[name]; ok {
+		return "<mi>" + s + "</mi>"
+	}
+	if s, ok := texOperators[name]; ok {
+		return "<mo>" + s + "</mo>"
+	}
+	if s, ok := texBigOperators[name]; ok {
+		return `<mo movablelimits="true">` + s + "</mo>"
+	}
+	if w, ok := texSpaces[name]; ok {
+		return `<mspace width="` + w + `"></mspace>`
+	}
+	if s, ok := texAccents[name]; ok {
+		return `<mover accent="true">` + p.arg() + `<mo stretchy="true">` + s + "</mo></mover>"
+	}
+	if v, ok := texFonts[name]; ok {
+		return texFont(p.raw(), v)
+	}
+	if texFunctions[name] {
+		return `<mi>` + name + `</mi>`
+	}
+	switch name {
+	case "lim", "max", "min", "sup", "inf", "det", "gcd", "Pr":
+		return `<mo movablelimits="true" form="prefix">` + name + `</mo>`
+	case "frac", "dfrac", "tfrac", "cfrac":
+		num := p.arg()
+		return "<mfrac>" + num + p.arg() + "</mfrac>"
+	case "binom":
+		top := p.arg()
+		return `<mrow><mo>(</mo><mfrac linethickness="0">` + top + p.arg() + `</mfrac><mo>)</mo></mrow>`
+	case "sqrt":
+		p.skipSpace()
+		if p.peek() == '[' {
+			p.pos++
+			outer := p.index
+			p.index = true
+			index := p.row()
+			p.index = outer
+			if p.peek() == ']' {
+				p.pos++
+			}
+			return "<mroot>" + p.arg() + "<mrow>" + index + "</mrow></mroot>"
+		}
+		return "<msqrt>" + p.arg() + "</msqrt>"
+	case "text", "textrm", "textnormal", "mbox", "textit", "textbf":
+		return "<mtext>" + html.EscapeString(p.raw()) + "</mtext>"
+	case "operatorname":
+		return `<mi mathvariant="normal">` + html.EscapeString(p.raw()) + `</mi>`
+	case "underline":
+		return `<munder accentunder="true">` + p.arg() + `<mo stretchy="true">_</mo></munder>`
+	case "left":
+		open := p.delimiter()
+		inner := p.row()
+		close := ""
+		if p.atCommand("right") {
+			p.command()
+			close = p.delimiter()
+		}
+		return "<mrow>" + open + inner + close + "</mrow>"
+	case "big", "Big", "bigg", "Bigg", "bigl", "bigr", "Bigl", "Bigr", "middle":
+		return p.delimiter()
+	case "begin":
+		return p.environment(p.raw())
+	}
+	return texError(`\` + name)
+}
+
+// delimiter parses the delimiter after \left or \right
+func (p *texParser) delimiter() string {
+	p.skipSpace()
+	if p.eof() {
+		return ""
+	}
+	var d string
+	if p.peek() == '\\' {
+		name := p.command()
+		if s, ok := texOperators[name]; ok {
+			d = s
+		} else {
+			return texError(`\` + name)
+		}
+	} else {
+		r, size := utf8.DecodeRuneInString(p.src[p.pos:])
+		p.pos += size
+		d = string(r)
+	}
+	if d == "." {
+		return ""
+	}
+	return `<mo fence="true" stretchy="true">` + html.EscapeString(d) + "</mo>"
+}
+
+// environment parses the rows of a matrix like environment, until \end
+func (p *texParser) environment(name string) string {
+	var table strings.Builder
+	align := ""
+	switch name {
+	case "cases":
+		align = ` columnalign="left left"`
+	case "aligned", "align", "align*", "split":
+		align = ` columnalign="right left"`
+	}
+	table.WriteString("<mtable" + align + ">")
+	for {
+		table.WriteString("<mtr>")
+		for {
+			table.WriteString("<mtd>" + p.row() + "</mtd>")
+			if p.peek() != '&' {
+				break
+			}
+			p.pos++
+		}
+		table.WriteString("</mtr>")
+		if !strings.HasPrefix(p.src[p.pos:], `\\`) {
+			break
+		}
+		p.pos += 2
+	}
+	table.WriteString("</mtable>")
+	if p.atCommand("end") {
+		p.command()
+		p.raw()
+	}
+
+	fences, ok := texEnvironments[name]
+	if !ok {
+		return texError(`\begin{` + name + `}`)
+	}
+	var b strings.Builder
+	b.WriteString("<mrow>")
+	if fences[0] != "" {
+		b.WriteString(`<mo fence="true" stretchy="true">` + fences[0] + "</mo>")
+	}
+	b.WriteString(table.String())
+	if fences[1] != "" {
+		b.WriteString(`<mo fence="true" stretchy="true">` + fences[1] + "</mo>")
+	}
+	b.WriteString("</mrow>")
+	return b.String()
+}
+
+// texFont returns letters in a math alphabet, like \mathbb{R}
+func texFont(text, variant string) string {
+	if variant == "normal" {
+		return `<mi mathvariant="normal">` + html.EscapeString(text) + "</mi>"
+	}
+	var b strings.Builder
+	for _, r := range text {
+		switch {
+		case r == ' ':
+			continue
+		case r >= '0' && r <= '9':
+			b.WriteString("<mn>" + string(r) + "</mn>")
+			continue
+		}
+		b.WriteString("<mi>" + html.EscapeString(string(mathLetter(r, variant))) + "</mi>")
+	}
+	return "<mrow>" + b.String() + "</mrow>"
+}
+
+// mathLetter returns a letter of a unicode mathematical alphanumeric alphabet
+func mathLetter(r rune, variant string) rune {
+	if s, ok := texLetterExceptions[variant][r]; ok {
+		return s
+	}
+	base, ok := map[string]rune{
+		"bold":          0x1D400,
+		"italic":        0x1D434,
+		"double-struck": 0x1D538,
+		"script":        0x1D49C,
+		"fraktur":       0x1D504,
+		"sans-serif":    0x1D5A0,
+		"monospace":     0x1D670,
+	}[variant]
+	switch {
+	case !ok:
+		return r
+	case r >= 'A' && r <= 'Z':
+		return base + r - 'A'
+	case r >= 'a' && r <= 'z':
+		return base + 26 + r - 'a'
+	}
+	return r
+}
+
+// texError shows source that could not be converted
+func texError(s string) string {
+	return `<merror><mtext>` + html.EscapeString(s) + `</mtext></merror>`
+}
+
+// letters that are not in the mathematical alphanumeric block
+var texLetterExceptions = map[string]map[rune]rune{
+	"italic":        {'h': 'ℎ'},
+	"double-struck": {'C': 'ℂ', 'H': 'ℍ', 'N': 'ℕ', 'P': 'ℙ', 'Q': 'ℚ', 'R': 'ℝ', 'Z': 'ℤ'},
+	"script":        {'B': 'ℬ', 'E': 'ℰ', 'F': 'ℱ', 'H': 'ℋ', 'I': 'ℐ', 'L': 'ℒ', 'M': 'ℳ', 'R': 'ℛ', 'e': 'ℯ', 'g': 'ℊ', 'o': 'ℴ'},
+	"fraktur":       {'C': 'ℭ', 'H': 'ℌ', 'I': 'ℑ', 'R': 'ℜ', 'Z': 'ℨ'},
+}
+
+// environments, and the delimiters around them
+var texEnvironments = map[string][2]string{
+	"matrix": {}, "array": {}, "aligned": {}, "align": {}, "align*": {}, "split": {}, "gathered": {},
+	"pmatrix": {"(", ")"}, "bmatrix": {"[", "]"}, "Bmatrix": {"{", "}"},
+	"vmatrix": {"|", "|"}, "Vmatrix": {"‖", "‖"}, "cases": {"{", ""},
+}
+
+var texFonts = map[string]string{
+	"mathbf":     "bold",
+	"boldsymbol": "bold",
+	"mathit":     "italic",
+	"mathbb":     "double-struck",
+	"mathcal":    "script",
+	"mathscr":    "script",
+	"mathfrak":   "fraktur",
+	"mathsf":     "sans-serif",
+	"mathtt":     "monospace",
+	"mathrm":     "normal",
+}
+
+var texFunctions = map[string]bool{
+	"sin": true, "cos": true, "tan": true, "cot": true, "sec": true, "csc": true,
+	"arcsin": true, "arccos": true, "arctan": true, "sinh": true, "cosh": true, "tanh": true,
+	"log": true, "ln": true, "lg": true, "exp": true, "arg": true, "deg": true, "dim": true,
+	"ker": true, "hom": true,
+}
+
+var texSpaces = map[string]string{
+	",": "0.167em", ":": "0.222em", ">": "0.222em", ";": "0.278em", " ": "0.333em",
+	"!": "-0.167em", "quad": "1em", "qquad": "2em",
+}
+
+var texAccents = map[string]string{
+	"hat": "^", "widehat": "^", "bar": "¯", "overline": "¯", "vec": "→", "overrightarrow": "→",
+	"tilde": "~", "widetilde": "~", "dot": "˙", "ddot": "¨", "check": "ˇ", "breve": "˘", "acute": "´", "grave": "`",
+}
+
+var texBigOperators = map[string]string{
+	"sum": "∑", "prod": "∏", "coprod": "∐", "bigcup": "⋃", "bigcap": "⋂", "bigoplus": "⨁",
+	"bigotimes": "⨂", "bigvee": "⋁", "bigwedge": "⋀",
+}
+
+var texIdentifiers = map[string]string{
+	"alpha": "α", "beta": "β", "gamma": "γ", "delta": "δ", "epsilon": "ϵ", "varepsilon": "ε",
+	"zeta": "ζ", "eta": "η", "theta": "θ", "vartheta": "ϑ", "iota": "ι", "kappa": "κ",
+	"lambda": "λ", "mu": "μ", "nu": "ν", "xi": "ξ", "pi": "π", "varpi": "ϖ", "rho": "ρ",
+	"varrho": "ϱ", "sigma": "σ", "varsigma": "ς", "tau": "τ", "upsilon": "υ", "phi": "ϕ",
+	"varphi": "φ", "chi": "χ", "psi": "ψ", "omega": "ω",
+	"Gamma": "Γ", "Delta": "Δ", "Theta": "Θ", "Lambda": "Λ", "Xi": "Ξ", "Pi": "Π",
+	"Sigma": "Σ", "Upsilon": "Υ", "Phi": "Φ", "Psi": "Ψ", "Omega": "Ω",
+	"infty": "∞", "partial": "∂", "nabla": "∇", "hbar": "ℏ", "ell": "ℓ", "emptyset": "∅",
+	"varnothing": "∅", "aleph": "ℵ", "Re": "ℜ", "Im": "ℑ", "wp": "℘", "imath": "ı", "jmath": "ȷ",
+}
+
+var texOperators = map[string]string{
+	"int": "∫", "iint": "∬", "iiint": "∭", "oint": "∮",
+	"pm": "±", "mp": "∓", "times": "×", "div": "÷", "cdot": "⋅", "ast": "∗", "star": "⋆",
+	"circ": "∘", "bullet": "∙", "oplus": "⊕", "otimes": "⊗", "ominus": "⊖", "odot": "⊙",
+	"cup": "∪", "cap": "∩", "setminus": "∖", "wedge": "∧", "land": "∧", "vee": "∨", "lor": "∨",
+	"neg": "¬", "lnot": "¬",
+	"leq": "≤", "le": "≤", "geq": "≥", "ge": "≥", "neq": "≠", "ne": "≠", "ll": "≪", "gg": "≫",
+	"approx": "≈", "equiv": "≡", "sim": "∼", "simeq": "≃", "cong": "≅", "propto": "∝",
+	"in": "∈", "notin": "∉", "ni": "∋", "subset": "⊂", "supset": "⊃", "subseteq": "⊆",
+	"supseteq": "⊇", "perp": "⊥", "parallel": "∥", "mid": "∣", "models": "⊨", "vdash": "⊢",
+	"to": "→", "rightarrow": "→", "leftarrow": "←", "gets": "←", "leftrightarrow": "↔",
+	"Rightarrow": "⇒", "Leftarrow": "⇐", "Leftrightarrow": "⇔", "implies": "⟹", "iff": "⟺",
+	"mapsto": "↦", "uparrow": "↑", "downarrow": "↓", "longrightarrow": "⟶", "longleftarrow": "⟵",
+	"forall": "∀", "exists": "∃", "nexists": "∄", "therefore": "∴", "because": "∵",
+	"ldots": "…", "cdots": "⋯", "vdots": "⋮", "ddots": "⋱", "dots": "…", "prime": "′",
+	"angle": "∠", "triangle": "△",
+	"langle": "⟨", "rangle": "⟩", "lfloor": "⌊", "rfloor": "⌋", "lceil": "⌈", "rceil": "⌉",
+	"lvert": "|", "rvert": "|", "vert": "|", "lVert": "‖", "rVert": "‖", "Vert": "‖", "|": "‖",
+	"{": "{", "}": "}", "lbrace": "{", "rbrace": "}", "backslash": "∖",
+	"%": "%", "$": "$", "#": "#", "&": "&amp;", "_": "_",
+}
@@ -0,0 +1,237 @@
+package handler
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v2"
+)
+
+// NavItem is an entry of the navigation defined by Options.Nav, for templates
+type NavItem struct {
+	Title    string
+	Name     string    // file name in Root, "" for part titles, drafts and other sites
+	URL      string    // link with the base path, "" for part titles and drafts
+	Current  bool      // the page being served
+	Open     bool      // the page being served is this one, or one of its children
+	Children []NavItem // nested entries
+}
+
+// navigation of the page being served, for templates
+type pageNav struct {
+	Nav         []NavItem // every entry, with the current page marked
+	Breadcrumbs []NavItem // entries leading to the current page, and the page
+	Prev, Next  *NavItem  // pages before and after the current page, in reading order
+}
+
+// a list item of SUMMARY.md, like "  - [Title](file.md)", or a prefix chapter without the dash
+var summaryItemRegexp = regexp.MustCompile(`^(\s*)(?:[-*+]\s+)?\[(.*)\]\((.*)\)\s*$`)
+
+// a part title of SUMMARY.md, like "# Reference"
+var summaryPartRegexp = regexp.MustCompile(`^#+\s+(.*?)\s*#*\s*$`)
+
+// navCache keeps the parsed navigation until the file changes
+type navCache struct {
+	mu    sync.Mutex
+	state fileState
+	items []NavItem
+}
+
+// navItems returns the navigation of Options.Nav, or nil if it can not be read
+func (h *handler) navItems() []NavItem {
+	state := statFile(h.Root, h.Nav)
+	h.navCache.mu.Lock()
+	defer h.navCache.mu.Unlock()
+	if h.navCache.items != nil && h.navCache.state == state {
+		return h.navCache.items
+	}
+	b, err := fs.ReadFile(h.Root, h.Nav)
+	if err != nil {
+		h.Logger.Println("error reading navigation:", err)
+		return nil
+	}
+	items, err := h.parseNav(h.Nav, b)
+	if err != nil {
+		h.Logger.Printf("error in navigation %s: %v", h.Nav, err)
+		return nil
+	}
+	h.navCache.state, h.navCache.items = state, items
+	return items
+}
+
+// parseNav parses an mdBook SUMMARY.md, or a mkdocs style yaml list when name ends in .yml or .yaml,
+// of items like "Home: index.md", "guide/more.md", or "Guide:" followed by a nested list
+func (h *handler) parseNav(name string, b []byte) ([]NavItem, error) {
+	if strings.HasSuffix(name, ".yml") || strings.HasSuffix(name, ".yaml") {
+		var list []interface{}
+		if err := yaml.Unmarshal(b, &list); err != nil {
+			return nil, err
+		}
+		return h.yamlNav(path.Dir(name), list)
+	}
+	return h.summaryNav(path.Dir(name), b), nil
+}
+
+// navEntry is an item of SUMMARY.md, before nesting by indentation
+type navEntry struct {
+	indent int
+	item   NavItem
+}
+
+// summaryNav parses the links of a SUMMARY.md, nested by list indentation, and its part titles
+func (h *handler) summaryNav(dir string, b []byte) []NavItem {
+	var entries []navEntry
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	var fence []byte
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		trimmed := []byte(strings.TrimLeft(line, " "))
+		if fence != nil {
+			if closesFence(trimmed, fence) {
+				fence = nil
+			}
+			continue
+		}
+		if fence = opensFence(trimmed); fence != nil {
+			continue
+		}
+		if m := summaryItemRegexp.FindStringSubmatch(line); m != nil {
+			indent := len(strings.Replace(m[1], "\t", "    ", -1))
+			entries = append(entries, navEntry{indent, h.navLink(dir, m[2], m[3])})
+		} else if m := summaryPartRegexp.FindStringSubmatch(line); m != nil && !strings.EqualFold(m[1], "summary") {
+			entries = append(entries, navEntry{0, NavItem{Title: m[1]}})
+		}
+	}
+	return navTree(entries)
+}
+
+// navTree nests entries under the entry before them with less indentation
+func navTree(entries []navEntry) []NavItem {
+	var items []NavItem
+	for i := 0; i < len(entries); {
+		j := i + 1
+		for j < len(entries) && entries[j].indent > entries[i].indent {
+			j++
+		}
+		item := entries[i].item
+		item.Children = navTree(entries[i+1 : j])
+		items = append(items, item)
+		i = j
+	}
+	return items
+}
+
+// yamlNav returns the items of a yaml list of "Title: file.md", "file.md", or "Title:" and a list
+func (h *handler) yamlNav(dir string, list []interface{}) ([]NavItem, error) {
+	var items []NavItem
+	for _, v := range list {
+		switch v := v.(type) {
+		case string:
+			items = append(items, h.navLink(dir, "", v))
+		case map[interface{}]interface{}:
+			if len(v) != 1 {
+				return nil, fmt.Errorf("want one title per item, got %d", len(v))
+			}
+			for title, value := range v {
+				switch value := value.(type) {
+				case string:
+					items = append(items, h.navLink(dir, fmt.Sprint(title), value))
+				case []interface{}:
+					children, err := h.yamlNav(dir, value)
+					if err != nil {
+						return nil, err
+					}
+					items = append(items, NavItem{Title: fmt.Sprint(title), Children: children})
+				default:
+					return nil, fmt.Errorf("%v: want a file or a list", title)
+				}
+			}
+		default:
+			return nil, fmt.Errorf("want a file, or title and file, got %v", v)
+		}
+	}
+	return items, nil
+}
+
+// navLink returns the item for a link in the navigation file in dir.
+// pages without a title are titled by their first heading.
+func (h *handler) navLink(dir, title, link string) NavItem {
+	item := NavItem{Title: title}
+	if title == "" {
+		item.Title = link
+	}
+	if link == "" {
+		return item // draft
+	}
+	if u, err := url.Parse(link); err != nil || u.Scheme != "" || u.Host != "" {
+		item.URL = link
+		return item
+	}
+	file, fragment := link, ""
+	if i := strings.IndexByte(link, '#'); i != -1 {
+		file, fragment = link[:i], link[i:]
+	}
+	item.Name = path.Join(dir, file)
+	if strings.HasPrefix(file, "/") {
+		item.Name = path.Clean(file[1:])
+	}
+	item.URL = (&url.URL{Path: h.BasePath + "/" + item.Name}).String() + fragment
+	if title == "" {
+		if b, err := fs.ReadFile(h.Root, item.Name); err == nil && h.fileisgood(item.Name) {
+			item.Title = pageTitle(item.Name, b)
+		}
+	}
+	return item
+}
+
+// navFor returns the navigation with page name marked as current
+func navFor(items []NavItem, name string) pageNav {
+	var nav pageNav
+	var pages []*NavItem // reading order
+	var mark func(items []NavItem, parents []NavItem) ([]NavItem, bool)
+	mark = func(items []NavItem, parents []NavItem) ([]NavItem, bool) {
+		marked := make([]NavItem, len(items))
+		open := false
+		for i, item := range items {
+			item.Current = item.Name != "" && item.Name == name && nav.Breadcrumbs == nil
+			if item.Current {
+				for _, p := range append(parents, item) {
+					p.Children = nil
+					nav.Breadcrumbs = append(nav.Breadcrumbs, p)
+				}
+			}
+			if item.Name != "" {
+				p := item
+				p.Children = nil
+				pages = append(pages, &p)
+			}
+			var childOpen bool
+			item.Children, childOpen = mark(item.Children, append(parents, item))
+			item.Open = item.Current || childOpen
+			open = open || item.Open
+			marked[i] = item
+		}
+		return marked, open
+	}
+	nav.Nav, _ = mark(items, nil)
+
+	for i, p := range pages {
+		if p.Current {
+			if i > 0 {
+				nav.Prev = pages[i-1]
+			}
+			if i < len(pages)-1 {
+				nav.Next = pages[i+1]
+			}
+			break
+		}
+	}
+	return nav
+}
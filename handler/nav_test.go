@@ -0,0 +1,77 @@
+package handler
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNav(t *testing.T) {
+	files := map[string]string{"SUMMARY.md": strings.Join([]string{
+		"# Summary",
+		"",
+		"[Introduction](README.md)",
+		"",
+		"- [Install](install.md)",
+		"- [Guide](guide/index.md)",
+		"    - [Start](guide/start.md)",
+		"\t- [Deep](guide/deep.md#top)",
+		"- [Draft]()",
+		"",
+		"```",
+		"- [Not a page](nope.md)",
+		"```",
+		"# Reference",
+		"- [Api](/api.md)",
+		"- [Site](https://example.com/)",
+	}, "\n")}
+	for _, name := range []string{"README.md", "install.md", "guide/index.md", "guide/start.md", "guide/deep.md", "api.md"} {
+		files[name] = "# Title of " + name + "\n"
+	}
+	dir := tempDocs(t, files)
+
+	footer := `{{range .Breadcrumbs}}/{{.Title}}{{end}}|{{with .Prev}}{{.Title}} {{.URL}}{{end}}|{{with .Next}}{{.Title}} {{.URL}}{{end}}|` +
+		`{{define "nav"}}{{range .}}[{{.Title}}{{if .Current}}*{{else if .Open}}+{{end}}{{template "nav" .Children}}]{{end}}{{end}}{{template "nav" .Nav}}`
+	h := newHandler(t, Options{Root: DirFS(dir), Index: "README.md", BasePath: "/docs", Nav: "SUMMARY.md", Footer: []byte(footer)})
+	get := func(path string) string {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		body := w.Body.String()
+		return body[strings.LastIndex(body, "\n")+1:]
+	}
+	for path, want := range map[string]string{
+		"/guide/start.md": "/Guide/Start|Guide /docs/guide/index.md|Deep /docs/guide/deep.md#top|" +
+			"[Introduction][Install][Guide+[Start*][Deep]][Draft][Reference][Api][Site]",
+		"/": "/Introduction||Install /docs/install.md|" +
+			"[Introduction*][Install][Guide[Start][Deep]][Draft][Reference][Api][Site]",
+		"/api.md":        "/Api|Deep /docs/guide/deep.md#top||",
+		"/guide/deep.md": "/Guide/Deep|Start /docs/guide/start.md|Api /docs/api.md|",
+		"/other.md":      "",
+	} {
+		if got := get(path); !strings.HasPrefix(got, want) {
+			t.Errorf("%s: expected %q, got %q", path, want, got)
+		}
+	}
+
+	// yaml, reloaded when it changes
+	writeDocs(t, dir, map[string]string{"nav.yaml": "- Home: README.md\n- Guide:\n    - guide/start.md\n    - Deep: guide/deep.md\n"})
+	h = newHandler(t, Options{Root: DirFS(dir), Nav: "nav.yaml", Footer: []byte(footer)})
+	if got, want := get("/guide/start.md"), "/Guide/Title of guide/start.md|Home /README.md|Deep /guide/deep.md|[Home][Guide+[Title of guide/start.md*][Deep]]"; got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+	writeDocs(t, dir, map[string]string{"nav.yaml": "- README.md\n- guide/start.md\n"})
+	if got, want := get("/guide/start.md"), "/Title of guide/start.md|Title of README.md /README.md||[Title of README.md][Title of guide/start.md*]"; got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+
+	// checked for pages that are missing
+	writeDocs(t, dir, map[string]string{"nav.yaml": "- README.md\n- Gone: gone.md\n"})
+	broken, err := Check(Options{Root: DirFS(dir), Index: "README.md", Nav: "nav.yaml"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(broken) != 2 || broken[0].String() != "SUMMARY.md: guide/deep.md#top: no anchor #top in guide/deep.md" ||
+		broken[1].String() != "nav.yaml: gone.md: not found" {
+		t.Errorf("unexpected broken links %v", broken)
+	}
+}
@@ -0,0 +1,132 @@
+package handler
+
+import (
+	"bytes"
+	"html/template"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// url path of the broken links report, after the base path
+const brokenLinksPath = "/_admin/broken-links"
+
+// most paths remembered, so the report can not grow forever
+const maxNotFound = 1000
+
+// notFoundLog counts requests answered with 404, by path and referer
+type notFoundLog struct {
+	mu    sync.Mutex
+	paths map[string]*notFound
+}
+
+type notFound struct {
+	Path     string
+	Count    int
+	Last     time.Time
+	Referers map[string]int
+}
+
+func newNotFoundLog() *notFoundLog {
+	return &notFoundLog{paths: make(map[string]*notFound)}
+}
+
+func (l *notFoundLog) add(p, referer string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	nf, ok := l.paths[p]
+	if !ok {
+		if len(l.paths) >= maxNotFound {
+			return
+		}
+		nf = &notFound{Path: p, Referers: make(map[string]int)}
+		l.paths[p] = nf
+	}
+	nf.Count++
+	nf.Last = time.Now()
+	if referer != "" && (len(nf.Referers) < maxNotFound || nf.Referers[referer] > 0) {
+		nf.Referers[referer]++
+	}
+}
+
+// list returns a copy of the log, most requested first
+func (l *notFoundLog) list() []notFound {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	list := make([]notFound, 0, len(l.paths))
+	for _, nf := range l.paths {
+		c := *nf
+		c.Referers = make(map[string]int, len(nf.Referers))
+		for k, v := range nf.Referers {
+			c.Referers[k] = v
+		}
+		list = append(list, c)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Count != list[j].Count {
+			return list[i].Count > list[j].Count
+		}
+		return list[i].Path < list[j].Path
+	})
+	return list
+}
+
+// statusWriter remembers the status code of a response
+type statusWriter struct {
+	http.ResponseWriter
+	code int
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if w.code == 0 {
+		w.code = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush keeps live reload streams working
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+var brokenLinksTemplate = template.Must(template.New("broken-links").Parse(`<h1>Broken links</h1>
+<p>Requests answered with 404 since the server started, most requested first.</p>
+<table class="broken-links">
+<tr><th>Path</th><th>Requests</th><th>Last</th><th>Linked from</th></tr>
+{{range .}}<tr><td><code>{{.Path}}</code></td><td>{{.Count}}</td><td>{{.Last.Format "2006-01-02 15:04:05"}}</td>
+<td>{{range $referer, $count := .Referers}}<a href="{{$referer}}" rel="nofollow">{{$referer}}</a> ({{$count}})<br>
+{{end}}</td></tr>
+{{else}}<tr><td colspan="4">none yet</td></tr>
+{{end}}</table>
+`))
+
+// serveBrokenLinks writes the 404 report, to logged in users
+func (h *handler) serveBrokenLinks(w http.ResponseWriter, r *http.Request) {
+	if h.Auth == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if _, ok := h.Auth.Authenticate(r); !ok {
+		w.Header().Set("WWW-Authenticate", `Basic realm="markdownd", charset="UTF-8"`)
+		http.Error(w, "401 unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var buf bytes.Buffer
+	if err := brokenLinksTemplate.Execute(&buf, h.notFound.list()); err != nil {
+		h.Logger.Println("error executing broken links template:", err)
+		http.Error(w, "500 internal server error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	h.servePage(w, r, "", buf.Bytes())
+}
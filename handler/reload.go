@@ -0,0 +1,118 @@
+package handler
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// url path of the live reload event stream, after the base path
+const reloadPath = "/_reload"
+
+// reloader sends the names of changed files to every listening page
+type reloader struct {
+	mu   sync.Mutex
+	subs map[chan string]struct{}
+}
+
+func newReloader() *reloader {
+	return &reloader{subs: make(map[chan string]struct{})}
+}
+
+func (rl *reloader) subscribe() chan string {
+	ch := make(chan string, 16)
+	rl.mu.Lock()
+	rl.subs[ch] = struct{}{}
+	rl.mu.Unlock()
+	return ch
+}
+
+func (rl *reloader) unsubscribe(ch chan string) {
+	rl.mu.Lock()
+	delete(rl.subs, ch)
+	rl.mu.Unlock()
+}
+
+// publish name to every subscriber, without waiting for slow ones
+func (rl *reloader) publish(name string) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	for ch := range rl.subs {
+		select {
+		case ch <- name:
+		default:
+		}
+	}
+}
+
+// changed is called after name is written, removed, or renamed
+func (h *handler) changed(name string) {
+	h.Logger.Println("changed:", name)
+
+	// pages including name changed too
+	includers := make(map[string]bool)
+	for _, p := range h.renderCache.forget(name) {
+		includers[p] = true
+	}
+	if h.links != nil {
+		for _, p := range h.links.includers(name) {
+			includers[p] = true
+		}
+		h.links.update(h, name)
+		for p := range includers {
+			h.links.update(h, p)
+		}
+	}
+	if h.taxonomy != nil {
+		h.taxonomy.update(h, name)
+	}
+	h.commitCache.forget(name)
+	h.reload.publish(name)
+	for p := range includers {
+		h.reload.publish(p)
+	}
+}
+
+// serveReload streams the names of changed files as server-sent events
+func (h *handler) serveReload(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	ch := h.reload.subscribe()
+	defer h.reload.unsubscribe(ch)
+	keepalive := time.NewTicker(30 * time.Second)
+	defer keepalive.Stop()
+	for {
+		select {
+		case name := <-ch:
+			if strings.ContainsAny(name, "\r\n") {
+				continue
+			}
+			fmt.Fprintf(w, "data: %s\n\n", name)
+		case <-keepalive.C:
+			io.WriteString(w, ": keepalive\n\n")
+		case <-r.Context().Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+// reloadScript reloads the page when its file changes
+var reloadScript = template.Must(template.New("reload").Parse(`<script>
+new EventSource({{.Base}} + "/_reload").onmessage = function(e) {
+	if (e.data === {{.Name}}) location.reload();
+};
+</script>
+`))
@@ -0,0 +1,167 @@
+package handler
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/microcosm-cc/bluemonday"
+)
+
+// Sanitizer cleans html produced by any markdown renderer
+type Sanitizer struct {
+	name       string
+	policy     *bluemonday.Policy // nil means no sanitization
+	shortcodes *bluemonday.Policy // policy plus youtube embeds, for the html of shortcodes
+	skipHTML   bool               // drop raw html found in markdown source
+}
+
+// NewSanitizer returns a named policy, or loads an allowlist file:
+//
+//	"ugc" (or "") allows user generated content, like github
+//	"strict" also drops raw html written in markdown source
+//	"none" does no sanitization, for trusted content only
+//
+// Any other name is the filename of an allowlist extending "ugc", see loadAllowlist.
+// Shortcodes can also embed youtube videos, unless an allowlist sets the sources of iframes.
+func NewSanitizer(name string) (*Sanitizer, error) {
+	switch name {
+	case "ugc", "":
+		return &Sanitizer{name: "ugc", policy: ugcPolicy(), shortcodes: allowEmbeds(ugcPolicy())}, nil
+	case "strict":
+		return &Sanitizer{name: name, policy: ugcPolicy(), shortcodes: allowEmbeds(ugcPolicy()), skipHTML: true}, nil
+	case "none":
+		return &Sanitizer{name: name}, nil
+	default:
+		p, err := loadAllowlist(name, ugcPolicy())
+		if err != nil {
+			return nil, err
+		}
+		shortcodes, err := loadAllowlist(name, allowEmbeds(ugcPolicy()))
+		if err != nil {
+			return nil, err
+		}
+		return &Sanitizer{name: name, policy: p, shortcodes: shortcodes}, nil
+	}
+}
+
+// Name of the policy
+func (s *Sanitizer) Name() string {
+	return s.name
+}
+
+// sanitize html, or return it unchanged with the 'none' policy
+func (s *Sanitizer) sanitize(in []byte) []byte {
+	if s == nil || s.policy == nil {
+		return in
+	}
+	return s.policy.SanitizeBytes(in)
+}
+
+// sanitizeShortcode sanitizes the html of a shortcode, which may embed youtube videos
+func (s *Sanitizer) sanitizeShortcode(in []byte) []byte {
+	if s == nil || s.shortcodes == nil {
+		return in
+	}
+	return s.shortcodes.SanitizeBytes(in)
+}
+
+// ugcPolicy allows user generated content plus what the gfm renderer emits
+// (heading anchors, highlighted code, task lists), and what alerts, shortcodes and diagrams emit
+func ugcPolicy() *bluemonday.Policy {
+	p := bluemonday.UGCPolicy()
+	p.AllowAttrs("class").Matching(bluemonday.SpaceSeparatedTokens).OnElements("div", "span", "p", "figure", "img")
+	p.AllowAttrs("class", "name").Matching(bluemonday.SpaceSeparatedTokens).OnElements("a")
+	p.AllowAttrs("rel").Matching(regexp.MustCompile(`^nofollow$`)).OnElements("a")
+	p.AllowAttrs("aria-hidden").Matching(regexp.MustCompile(`^true$`)).OnElements("a")
+	p.AllowAttrs("type").Matching(regexp.MustCompile(`^checkbox$`)).OnElements("input")
+	p.AllowAttrs("checked", "disabled").Matching(regexp.MustCompile(`^$`)).OnElements("input")
+	p.AllowDataURIImages()
+	// svg images of diagrams, where scripts do not run
+	p.AllowURLSchemeWithCustomPolicy("data", func(u *url.URL) bool {
+		data := strings.TrimPrefix(u.Opaque, "image/svg+xml;base64,")
+		if data == u.Opaque || u.RawQuery != "" || u.Fragment != "" {
+			return false
+		}
+		_, err := base64.StdEncoding.DecodeString(data)
+		return err == nil
+	})
+	return p
+}
+
+// allowEmbeds extends p with the youtube iframes of the built-in shortcode
+func allowEmbeds(p *bluemonday.Policy) *bluemonday.Policy {
+	p.AllowAttrs("src").Matching(regexp.MustCompile(`^https://www\.youtube-nocookie\.com/embed/`)).OnElements("iframe")
+	p.AllowAttrs("title").Matching(bluemonday.Paragraph).OnElements("iframe")
+	p.AllowAttrs("allow").Matching(regexp.MustCompile(`^[a-z; -]*$`)).OnElements("iframe")
+	p.AllowAttrs("allowfullscreen").Matching(regexp.MustCompile(`^$`)).OnElements("iframe")
+	p.AllowAttrs("loading").Matching(regexp.MustCompile(`^lazy$`)).OnElements("iframe")
+	return p
+}
+
+// loadAllowlist extends policy p, like the ugc policy, with elements listed in a file.
+//
+// Each line names an element followed by its allowed attributes.
+// An attribute written as attr=host1,host2 only accepts https urls on those hosts.
+// Empty lines and lines starting with '#' are ignored.
+//
+//	details open
+//	summary
+//	iframe src=www.youtube-nocookie.com,player.vimeo.com width height allowfullscreen
+//	svg viewbox width height xmlns
+func loadAllowlist(filename string, p *bluemonday.Policy) (*bluemonday.Policy, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		elem := strings.ToLower(fields[0])
+		if !validName(elem) {
+			return nil, fmt.Errorf("%s:%d: bad element name %q", filename, n, elem)
+		}
+		p.AllowElements(elem)
+		for _, attr := range fields[1:] {
+			attr = strings.ToLower(attr)
+			hosts := ""
+			if i := strings.IndexByte(attr, '='); i != -1 {
+				attr, hosts = attr[:i], attr[i+1:]
+			}
+			if !validName(attr) {
+				return nil, fmt.Errorf("%s:%d: bad attribute name %q", filename, n, attr)
+			}
+			if hosts == "" {
+				p.AllowAttrs(attr).OnElements(elem)
+				continue
+			}
+			var quoted []string
+			for _, host := range strings.Split(hosts, ",") {
+				if host != "" {
+					quoted = append(quoted, regexp.QuoteMeta(host))
+				}
+			}
+			re := regexp.MustCompile(`^https://(` + strings.Join(quoted, "|") + `)(/|$)`)
+			p.AllowAttrs(attr).Matching(re).OnElements(elem)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+var validNameRegexp = regexp.MustCompile(`^[a-z][a-z0-9-]*$`)
+
+func validName(s string) bool {
+	return validNameRegexp.MatchString(s)
+}
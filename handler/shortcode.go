@@ -0,0 +1,310 @@
+package handler
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html"
+	"html/template"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// shortcodes like {{< figure src="a.png" >}}, or {{< details >}} and {{< /details >}} around markdown
+var shortcodeRegexp = regexp.MustCompile(`\{\{<\s*(/?)([\w-]+)((?:\s(?:[^>]|>[^}])*?)?)\s*>\}\}`)
+
+// variables like {{ .Site.Version }} and {{ .Meta.title }}
+var variableRegexp = regexp.MustCompile(`\{\{\s*\.(Site|Meta)((?:\.[\w-]+)+)\s*\}\}`)
+
+// built-in shortcode templates, which shortcode files of the same name replace
+const builtinShortcodes = `
+{{define "figure"}}<figure{{with .Get "class"}} class="{{.}}"{{end}}>
+{{- if .Get "link"}}<a href="{{.Get "link"}}">{{end -}}
+<img src="{{or (.Get 0) (.Get "src")}}" alt="{{or (.Get "alt") (.Get "caption")}}"{{with .Get "title"}} title="{{.}}"{{end}}>
+{{- if .Get "link"}}</a>{{end -}}
+{{with .Get "caption"}}<figcaption>{{.}}</figcaption>{{end}}</figure>{{end}}
+
+{{define "youtube"}}<div class="video video-youtube"><iframe src="https://www.youtube-nocookie.com/embed/{{or (.Get 0) (.Get "id")}}"
+ title="{{or (.Get "title") "YouTube video"}}" allow="encrypted-media; picture-in-picture" allowfullscreen loading="lazy"></iframe></div>{{end}}
+
+{{define "details"}}<details{{if .Get "open"}} open{{end}}><summary>{{or (.Get 0) (.Get "summary") "Details"}}</summary>{{.Inner}}</details>{{end}}
+`
+
+// Shortcodes expand {{< name >}} shortcodes and {{ .Site.Name }} variables in pages
+type Shortcodes struct {
+	site      map[string]interface{}
+	templates *template.Template
+}
+
+// NewShortcodes returns the built-in shortcodes ref, relref, figure, youtube and details,
+// with site variables for {{ .Site.Name }}, and the shortcode templates in dir if it is not "".
+// a template named button.html is the {{< button >}} shortcode.
+func NewShortcodes(site map[string]interface{}, dir string) (*Shortcodes, error) {
+	t := template.Must(template.New("").Parse(builtinShortcodes))
+	if dir != "" {
+		files, err := filepath.Glob(filepath.Join(dir, "*.html"))
+		if err != nil {
+			return nil, err
+		}
+		if len(files) == 0 {
+			if _, err := os.Stat(dir); err != nil {
+				return nil, err
+			}
+		}
+		for _, file := range files {
+			b, err := ioutil.ReadFile(file)
+			if err != nil {
+				return nil, err
+			}
+			name := strings.TrimSuffix(filepath.Base(file), ".html")
+			if _, err := t.New(name).Parse(string(b)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return &Shortcodes{site: site, templates: t}, nil
+}
+
+// Names returns the names of every shortcode
+func (s *Shortcodes) Names() []string {
+	names := []string{"ref", "relref"}
+	for _, t := range s.templates.Templates() {
+		if t.Name() != "" && t.Name() != "ref" && t.Name() != "relref" {
+			names = append(names, t.Name())
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// shortcode is the data of shortcode templates
+type shortcode struct {
+	Name  string                 // shortcode name
+	Args  []string               // "quoted" and bare arguments
+	Named map[string]string      // key="value" arguments
+	Inner template.HTML          // rendered markdown between {{< name >}} and {{< /name >}}
+	Page  string                 // file name of the page
+	Site  map[string]interface{} // site variables
+	Meta  map[string]interface{} // front matter of the page
+}
+
+// Get returns the argument at a position, or with a name, or ""
+func (s *shortcode) Get(key interface{}) string {
+	switch k := key.(type) {
+	case int:
+		if k >= 0 && k < len(s.Args) {
+			return s.Args[k]
+		}
+	case string:
+		return s.Named[k]
+	}
+	return ""
+}
+
+// variables replaces {{ .Site.Name }} and {{ .Meta.name }} in markdown, outside of code,
+// with their values. unknown variables are left alone.
+func (h *handler) variables(src []byte, meta map[string]interface{}) []byte {
+	return mapText(src, func(text []byte) []byte {
+		return variableRegexp.ReplaceAllFunc(text, func(v []byte) []byte {
+			m := variableRegexp.FindSubmatch(v)
+			vars := h.Shortcodes.site
+			if string(m[1]) == "Meta" {
+				vars = meta
+			}
+			value, ok := lookup(vars, strings.Split(string(m[2][1:]), "."))
+			if !ok {
+				return v
+			}
+			return []byte(fmt.Sprint(value))
+		})
+	})
+}
+
+// lookup returns the value at a path of keys in nested maps, like yaml makes
+func lookup(v interface{}, keys []string) (interface{}, bool) {
+	for _, key := range keys {
+		switch m := v.(type) {
+		case map[string]interface{}:
+			v = m[key]
+		case map[interface{}]interface{}:
+			v = m[key]
+		case map[string]string:
+			v = m[key]
+		default:
+			return nil, false
+		}
+		if v == nil {
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+// shortcodes expands shortcodes in the markdown of page name, outside of code.
+// shortcodes alone on their line are blocks, and their inner markdown is rendered like the rest of the page.
+// pages linked to with ref and relref are added to deps.
+func (h *handler) shortcodes(name string, src []byte, meta map[string]interface{}, ph *placeholders, deps *[]string) []byte {
+	var open []string // closing html of shortcodes with inner markdown, innermost last
+	var openNames []string
+	out := mapText(src, func(text []byte) []byte {
+		var b bytes.Buffer
+		last := 0
+		for _, m := range shortcodeRegexp.FindAllSubmatchIndex(text, -1) {
+			b.Write(text[last:m[0]])
+			last = m[1]
+			closing, sc := m[3] > m[2], string(text[m[4]:m[5]])
+			block := aloneOnLine(text, m[0], m[1])
+
+			if closing {
+				if len(openNames) == 0 || openNames[len(openNames)-1] != sc {
+					b.Write(h.shortcodeError(sc, errors.New("closed without being opened"), block, ph))
+					continue
+				}
+				b.Write(h.shortcodeHTML(open[len(open)-1], block, false, ph))
+				open, openNames = open[:len(open)-1], openNames[:len(openNames)-1]
+				continue
+			}
+
+			args, named := parseArgs(string(text[m[6]:m[7]]))
+			out, err := h.shortcode(name, sc, args, named, meta, deps)
+			if err != nil {
+				b.Write(h.shortcodeError(sc, err, block, ph))
+				continue
+			}
+			if out.markdown != "" {
+				b.WriteString(out.markdown)
+				continue
+			}
+			b.Write(h.shortcodeHTML(out.html, block, out.closing != "", ph))
+			if out.closing != "" {
+				open, openNames = append(open, out.closing), append(openNames, sc)
+			}
+		}
+		b.Write(text[last:])
+		return b.Bytes()
+	})
+
+	// unclosed shortcodes end with the page
+	for i := len(open) - 1; i >= 0; i-- {
+		out = append(out, h.shortcodeHTML(open[i], true, false, ph)...)
+	}
+	return out
+}
+
+// shortcodeOutput is markdown, like the url of ref, or html, split where inner markdown goes
+type shortcodeOutput struct {
+	markdown string
+	html     string
+	closing  string // html after the inner markdown
+}
+
+// shortcode runs shortcode sc of page name
+func (h *handler) shortcode(name, sc string, args []string, named map[string]string, meta map[string]interface{}, deps *[]string) (shortcodeOutput, error) {
+	t := h.Shortcodes.templates.Lookup(sc)
+	if t == nil && (sc == "ref" || sc == "relref") {
+		u, err := h.ref(name, args, sc == "relref", deps)
+		return shortcodeOutput{markdown: u}, err
+	}
+	if t == nil {
+		return shortcodeOutput{}, errors.New("unknown shortcode")
+	}
+
+	// a token in place of the inner markdown splits the html in two
+	inner := newPlaceholders().prefix + "inner"
+	var b bytes.Buffer
+	err := t.Execute(&b, &shortcode{Name: sc, Args: args, Named: named, Inner: template.HTML(inner),
+		Page: name, Site: h.Shortcodes.site, Meta: meta})
+	if err != nil {
+		return shortcodeOutput{}, err
+	}
+	out := b.String()
+	if i := strings.Index(out, inner); i != -1 {
+		return shortcodeOutput{html: out[:i], closing: out[i+len(inner):]}, nil
+	}
+	return shortcodeOutput{html: out}, nil
+}
+
+// ref returns the url of a page, relative to page name with relative, otherwise with the base path
+func (h *handler) ref(name string, args []string, relative bool, deps *[]string) (string, error) {
+	if len(args) != 1 {
+		return "", fmt.Errorf("want one file name, got %d", len(args))
+	}
+	file, fragment := args[0], ""
+	if i := strings.IndexByte(file, '#'); i != -1 {
+		file, fragment = file[:i], file[i:]
+	}
+	target, err := h.includeName(name, file)
+	if err != nil {
+		return "", fmt.Errorf("%s: %v", file, err)
+	}
+	*deps = append(*deps, target)
+	if err := h.checkInclude(target); err != nil {
+		return "", fmt.Errorf("%s: %v", file, err)
+	}
+	u := h.BasePath + "/" + target
+	if relative {
+		u = relativePath(path.Dir(name), target)
+	}
+	return (&url.URL{Path: u}).String() + fragment, nil
+}
+
+// relativePath returns the path of file from directory dir, both in Root
+func relativePath(dir, file string) string {
+	from := strings.Split(path.Clean(dir), "/")
+	to := strings.Split(file, "/")
+	if from[0] == "." {
+		from = nil
+	}
+	i := 0
+	for i < len(from) && i < len(to)-1 && from[i] == to[i] {
+		i++
+	}
+	return strings.Repeat("../", len(from)-i) + strings.Join(to[i:], "/")
+}
+
+// aloneOnLine returns true if only spaces are around text[start:end] on its line
+func aloneOnLine(text []byte, start, end int) bool {
+	lineStart := bytes.LastIndexByte(text[:start], '\n') + 1
+	lineEnd := bytes.IndexByte(text[end:], '\n')
+	if lineEnd == -1 {
+		lineEnd = len(text) - end
+	}
+	return len(bytes.TrimSpace(text[lineStart:start])) == 0 && len(bytes.TrimSpace(text[end:end+lineEnd])) == 0
+}
+
+// html starting with a block element, which can not be inside a paragraph
+var blockHTMLRegexp = regexp.MustCompile(`(?i)^\s*</?(address|article|aside|blockquote|details|dialog|div|dl|fieldset|figcaption|figure|footer|form|h[1-6]|header|hr|main|nav|ol|p|pre|section|summary|table|ul)\b`)
+
+// shortcodeHTML returns a placeholder for html sanitized like the rest of the page,
+// which is a block of its own when block, followed by the markdown inside it when opening.
+// inline html starting with a block element, like <div>, ends the paragraph around it.
+func (h *handler) shortcodeHTML(s string, block, opening bool, ph *placeholders) []byte {
+	b := h.Sanitizer.sanitizeShortcode([]byte(s))
+	if !block && !blockHTMLRegexp.Match(b) {
+		return ph.add(b)
+	}
+	token := ph.addBlock(b)
+	if !block {
+		return append(append([]byte("\n\n"), token...), "\n\n"...)
+	}
+	if opening {
+		return append(append([]byte("\n"), token...), "\n\n"...)
+	}
+	return append(append([]byte("\n\n"), token...), '\n')
+}
+
+// shortcodeError logs err, and returns a placeholder showing it
+func (h *handler) shortcodeError(sc string, err error, block bool, ph *placeholders) []byte {
+	h.Logger.Printf("error in shortcode %s: %v", sc, err)
+	msg := fmt.Sprintf("could not render shortcode %s: %s", html.EscapeString(sc), html.EscapeString(err.Error()))
+	if block {
+		return append(append([]byte("\n"), ph.addBlock([]byte(`<p class="shortcode-error">`+msg+`</p>`))...), '\n')
+	}
+	return ph.add([]byte(`<span class="shortcode-error">` + msg + `</span>`))
+}
@@ -0,0 +1,96 @@
+package handler
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestShortcodes(t *testing.T) {
+	dir := tempDocs(t, map[string]string{
+		"site/a.md":               "# A\n",
+		"site/sub/b.md":           "# B\n",
+		"shortcodes/button.html":  `<a class="button" href="{{.Get "href"}}" onclick="go()">{{.Get 0}}</a>`,
+		"shortcodes/youtube.html": `<p>video {{.Get 0}} of {{.Site.name}}</p>`,
+	})
+
+	sc, err := NewShortcodes(map[string]interface{}{"Version": "1.2", "name": "Docs"}, filepath.Join(dir, "shortcodes"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := strings.Join(sc.Names(), ","); names != "button,details,figure,ref,relref,youtube" {
+		t.Errorf("unexpected names %q", names)
+	}
+	h := newHandler(t, Options{Root: DirFS(filepath.Join(dir, "site")), BasePath: "/docs", Shortcodes: sc}).(*handler)
+	render := func(src string) string {
+		return string(h.renderPage("sub/page.md", []byte(src)).HTML)
+	}
+
+	for _, tc := range []struct {
+		src  string
+		want []string
+	}{
+		{"---\ntitle: Guide\n---\nv{{ .Site.Version }} of {{.Meta.title}}, [download](/dl/{{ .Site.Version }}/x.tgz) {{ .Site.nope }} `{{ .Site.Version }}`\n", []string{
+			`<p>v1.2 of Guide, <a href="/dl/1.2/x.tgz" rel="nofollow">download</a> {{ .Site.nope }} <code>{{ .Site.Version }}</code></p>`,
+		}},
+		{`[a]({{< ref "../a.md#top" >}}) [b]({{< relref "b.md" >}}) [b]({{< relref "/sub/b.md" >}}) [a]({{< relref "/a.md" >}}) {{< ref "nope.md" >}}`, []string{
+			`<a href="/docs/a.md#top" rel="nofollow">a</a>`,
+			`<a href="b.md" rel="nofollow">b</a> <a href="b.md" rel="nofollow">b</a> <a href="../a.md" rel="nofollow">a</a>`,
+			`<span class="shortcode-error">could not render shortcode ref: nope.md: not found</span>`,
+		}},
+		{`{{< figure src="cat.png" caption="A <b>cat</b>" link="javascript:alert(1)" >}}`, []string{
+			`<figure><a href="#ZgotmplZ" rel="nofollow"><img src="cat.png"></a><figcaption>A &lt;b&gt;cat&lt;/b&gt;</figcaption></figure>`,
+		}},
+		{"{{< details \"More\" >}}\n**Bold** <script>x</script>\n\n```\n{{< /details >}}\n```\n{{< /details >}}\n\nafter", []string{
+			"<details><summary>More</summary>\n\n<p><strong>Bold</strong> </p>\n\n<pre><code>{{&lt; /details &gt;}}\n</code></pre>\n\n</details>\n\n<p>after</p>",
+		}},
+		{`Press {{< button "Go" href="/go" >}} now. {{< youtube abc >}}`, []string{
+			"<p>Press <a class=\"button\" href=\"/go\" rel=\"nofollow\">Go</a> now.</p>\n\n<p>video abc of Docs</p>",
+		}},
+		{"{{< nope >}}\n\n{{< /details >}}", []string{
+			`<p class="shortcode-error">could not render shortcode nope: unknown shortcode</p>`,
+			`<p class="shortcode-error">could not render shortcode details: closed without being opened</p>`,
+		}},
+		{"{{< details >}}\nunclosed", []string{"<details><summary>Details</summary>\n\n<p>unclosed</p>\n\n</details>"}},
+	} {
+		out := render(tc.src)
+		for _, want := range tc.want {
+			if !strings.Contains(out, want) {
+				t.Errorf("%q: expected %q in %q", tc.src, want, out)
+			}
+		}
+	}
+
+	// built-ins without a directory, sanitized like the page, and off by default
+	sc, _ = NewShortcodes(nil, "")
+	h = newHandler(t, Options{Root: DirFS(dir), Shortcodes: sc}).(*handler)
+	if out := string(h.markdown2html([]byte(`{{< youtube abc >}}`))); !strings.Contains(out, `<div class="video video-youtube"><iframe src="https://www.youtube-nocookie.com/embed/abc" title="YouTube video"`) {
+		t.Errorf("unexpected youtube embed %q", out)
+	}
+	// only shortcodes embed videos, and only from youtube
+	if out := string(h.markdown2html([]byte(`<iframe src="https://www.youtube-nocookie.com/embed/abc"></iframe>`))); strings.Contains(out, "iframe") {
+		t.Errorf("unexpected iframe in %q", out)
+	}
+	if out := string(h.Sanitizer.sanitizeShortcode([]byte(`<iframe src="https://example.com/embed/abc"></iframe>`))); strings.Contains(out, "example.com") {
+		t.Errorf("unexpected iframe source in %q", out)
+	}
+	writeDocs(t, dir, map[string]string{"allow.txt": "iframe src=www.youtube-nocookie.com title allowfullscreen\n"})
+	allow, err := NewSanitizer(filepath.Join(dir, "allow.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	none, _ := NewSanitizer("none")
+	for _, s := range []*Sanitizer{allow, none} {
+		h = newHandler(t, Options{Root: DirFS(dir), Shortcodes: sc, Sanitizer: s}).(*handler)
+		if out := string(h.markdown2html([]byte(`{{< youtube "a\"b" >}}`))); !strings.Contains(out, `<iframe src="https://www.youtube-nocookie.com/embed/a%22b"`) {
+			t.Errorf("%s: unexpected youtube embed %q", s.Name(), out)
+		}
+	}
+	h = newHandler(t, Options{Root: DirFS(dir)}).(*handler)
+	if out := string(h.markdown2html([]byte(`{{< youtube abc >}} {{ .Site.Version }}`))); !strings.Contains(out, "{{&lt; youtube abc &gt;}} {{ .Site.Version }}") {
+		t.Errorf("expected no shortcodes, got %q", out)
+	}
+	if _, err := NewShortcodes(nil, filepath.Join(dir, "nope")); err == nil {
+		t.Error("expected error for a missing directory")
+	}
+}
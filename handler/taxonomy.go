@@ -0,0 +1,225 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// url path of the taxonomy data, after the base path
+const taxonomiesPath = "/_api/taxonomies"
+
+// Term is a value of a taxonomy, like the tag "go", and the pages that have it
+type Term struct {
+	Name  string `json:"name"`
+	URL   string `json:"url"` // listing of the pages, with the base path
+	Pages []Link `json:"pages"`
+}
+
+// termIndex knows the taxonomy terms in the front matter of every markdown page
+type termIndex struct {
+	mu    sync.RWMutex
+	pages map[string]*termPage
+}
+
+type termPage struct {
+	Title string
+	Date  time.Time           // of posts, which are listed once published
+	Terms map[string][]string // by taxonomy, like "tags"
+}
+
+// buildTerms indexes the front matter of every markdown page in Root
+func (h *handler) buildTerms() *termIndex {
+	idx := &termIndex{pages: make(map[string]*termPage)}
+	idx.update(h, ".")
+	return idx
+}
+
+// update reindexes name, which is a page or a directory of pages, or was removed
+func (idx *termIndex) update(h *handler, name string) {
+	pages := make(map[string]*termPage)
+	fs.WalkDir(h.Root, name, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if p != "." && !h.visible(p) {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if d.Type().IsRegular() && strings.HasSuffix(p, ".md") {
+			if b, err := fs.ReadFile(h.Root, p); err == nil {
+				if page := h.termPage(p, b); page != nil {
+					pages[p] = page
+				}
+			}
+		}
+		return nil
+	})
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	for p := range idx.pages {
+		if name == "." || p == name || strings.HasPrefix(p, name+"/") {
+			delete(idx.pages, p)
+		}
+	}
+	for p, page := range pages {
+		idx.pages[p] = page
+	}
+}
+
+// termPage returns the taxonomy terms of a page, or nil if it has none
+func (h *handler) termPage(name string, b []byte) *termPage {
+	meta, src := frontMatter(b)
+	if metaBool(meta, "draft", false) {
+		return nil
+	}
+	page := &termPage{Terms: make(map[string][]string)}
+	for _, taxonomy := range h.Taxonomies {
+		if terms := metaStrings(meta, taxonomy); len(terms) > 0 {
+			page.Terms[taxonomy] = terms
+		}
+	}
+	if len(page.Terms) == 0 {
+		return nil
+	}
+	if page.Title, _ = meta["title"].(string); page.Title == "" {
+		page.Title = pageTitle(name, src)
+	}
+	page.Date, _ = metaTime(meta, "date")
+	return page
+}
+
+// termList returns the terms of a taxonomy, sorted by name, with their pages sorted by title.
+// pages dated in the future are left out, like blog posts.
+func (h *handler) termList(taxonomy string) []Term {
+	h.taxonomy.mu.RLock()
+	byName := make(map[string][]Link)
+	now := time.Now()
+	for p, page := range h.taxonomy.pages {
+		if page.Date.After(now) {
+			continue
+		}
+		for _, term := range page.Terms[taxonomy] {
+			byName[term] = append(byName[term], Link{Name: p, Title: page.Title, URL: (&url.URL{Path: h.BasePath + "/" + p}).String()})
+		}
+	}
+	h.taxonomy.mu.RUnlock()
+
+	terms := []Term{}
+	for name, pages := range byName {
+		sort.Slice(pages, func(i, j int) bool {
+			if pages[i].Title != pages[j].Title {
+				return pages[i].Title < pages[j].Title
+			}
+			return pages[i].Name < pages[j].Name
+		})
+		terms = append(terms, Term{Name: name, URL: h.termURL(taxonomy, name), Pages: pages})
+	}
+	sort.Slice(terms, func(i, j int) bool { return terms[i].Name < terms[j].Name })
+	return terms
+}
+
+// termURL returns the listing of the pages with a term, like /tags/go/
+func (h *handler) termURL(taxonomy, term string) string {
+	return h.BasePath + "/" + url.PathEscape(taxonomy) + "/" + url.PathEscape(term) + "/"
+}
+
+// serveTaxonomy writes the listing of a taxonomy at /tags/, or of a term at /tags/go/,
+// redirects /tags to /tags/, and returns false for other paths and unknown terms
+func (h *handler) serveTaxonomy(w http.ResponseWriter, r *http.Request) bool {
+	if r.URL.Path == taxonomiesPath {
+		h.serveTerms(w, r)
+		return true
+	}
+	// split the escaped path, since terms like ci/cd hold a '/'
+	var parts []string
+	for _, part := range strings.Split(strings.Trim(r.URL.EscapedPath(), "/"), "/") {
+		part, err := url.PathUnescape(part)
+		if err != nil {
+			return false
+		}
+		parts = append(parts, part)
+	}
+	if len(parts) > 2 || !hasString(h.Taxonomies, parts[0]) {
+		return false
+	}
+	if !strings.HasSuffix(r.URL.Path, "/") {
+		if len(parts) != 1 {
+			return false
+		}
+		target := h.BasePath + r.URL.EscapedPath() + "/"
+		if r.URL.RawQuery != "" {
+			target += "?" + r.URL.RawQuery
+		}
+		http.Redirect(w, r, target, http.StatusMovedPermanently)
+		return true
+	}
+	listing := taxonomyListing{Taxonomy: parts[0], Terms: h.termList(parts[0])}
+	if len(parts) == 2 {
+		var found bool
+		for _, term := range listing.Terms {
+			if term.Name == parts[1] {
+				listing.Term, listing.Terms, found = &term, nil, true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := taxonomyTemplate.Execute(&buf, listing); err != nil {
+		h.Logger.Println("error executing taxonomy template:", err)
+		http.Error(w, "500 internal server error", http.StatusInternalServerError)
+		return true
+	}
+	h.servePage(w, r, "", buf.Bytes())
+	return true
+}
+
+// taxonomyListing is the data of the taxonomy template, with Term set on the page of a term
+type taxonomyListing struct {
+	Taxonomy string
+	Terms    []Term
+	Term     *Term
+}
+
+var taxonomyTemplate = template.Must(template.New("taxonomy").Parse(`{{with .Term}}<h1>{{$.Taxonomy}}: {{.Name}}</h1>
+<ul class="term-pages">
+{{range .Pages}}<li><a href="{{.URL}}">{{.Title}}</a></li>
+{{end}}</ul>
+{{else}}<h1>{{.Taxonomy}}</h1>
+<ul class="terms">
+{{range .Terms}}<li><a href="{{.URL}}">{{.Name}}</a> ({{len .Pages}})</li>
+{{else}}<li>none yet</li>
+{{end}}</ul>
+{{end}}`))
+
+// serveTerms writes every taxonomy, and its terms and pages, as json
+func (h *handler) serveTerms(w http.ResponseWriter, r *http.Request) {
+	taxonomies := make(map[string][]Term)
+	for _, taxonomy := range h.Taxonomies {
+		taxonomies[taxonomy] = h.termList(taxonomy)
+	}
+	b, err := json.MarshalIndent(struct {
+		Taxonomies map[string][]Term `json:"taxonomies"`
+	}{taxonomies}, "", "  ")
+	if err != nil {
+		h.Logger.Println("error encoding taxonomies:", err)
+		http.Error(w, "500 internal server error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
@@ -0,0 +1,114 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTaxonomies(t *testing.T) {
+	dir := tempDocs(t, map[string]string{
+		"a.md":           "---\ntags: [go, web]\ncategories: guides\n---\n# Ay\n",
+		"sub/b.md":       "---\ntitle: Bee\ntags: go, c++\n---\n",
+		"draft.md":       "---\ntags: [go]\ndraft: true\n---\n# Draft\n",
+		".hidden/x.md":   "---\ntags: [go]\n---\n# Hidden\n",
+		"tags/readme.md": "# Not a term\n",
+	})
+
+	h := newHandler(t, Options{
+		Root:       DirFS(dir),
+		BasePath:   "/docs",
+		Taxonomies: []string{"tags", "categories"},
+		Watch:      10 * time.Millisecond,
+		Footer:     []byte(`<footer>`),
+	})
+	get := func(path string) (int, string) {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w.Code, w.Body.String()
+	}
+
+	for path, want := range map[string]string{
+		"/tags/": `<h1>tags</h1>
+<ul class="terms">
+<li><a href="/docs/tags/c&#43;&#43;/">c&#43;&#43;</a> (1)</li>
+<li><a href="/docs/tags/go/">go</a> (2)</li>
+<li><a href="/docs/tags/web/">web</a> (1)</li>
+</ul>
+<footer>`,
+		"/tags/go/": `<h1>tags: go</h1>
+<ul class="term-pages">
+<li><a href="/docs/a.md">Ay</a></li>
+<li><a href="/docs/sub/b.md">Bee</a></li>
+</ul>
+<footer>`,
+		"/tags/c++/":   `<a href="/docs/sub/b.md">Bee</a>`,
+		"/categories/": `<li><a href="/docs/categories/guides/">guides</a> (1)</li>`,
+	} {
+		code, body := get(path)
+		if code != 200 || !strings.Contains(body, want) {
+			t.Errorf("%s: got %d, want %q in:\n%s", path, code, want, body)
+		}
+		if strings.Contains(body, "Draft") || strings.Contains(body, "Hidden") {
+			t.Errorf("%s: draft or hidden page in:\n%s", path, body)
+		}
+	}
+	for _, path := range []string{"/tags/none/", "/tags/go/more/", "/other/"} {
+		if code, _ := get(path); code != 404 {
+			t.Errorf("%s: got %d, want 404", path, code)
+		}
+	}
+	if _, body := get("/tags/readme.md"); !strings.Contains(body, "Not a term") {
+		t.Errorf("files in a taxonomy directory are served: %s", body)
+	}
+
+	var data struct {
+		Taxonomies map[string][]struct {
+			Name  string
+			URL   string
+			Pages []Link
+		}
+	}
+	_, body := get("/_api/taxonomies")
+	if err := json.Unmarshal([]byte(body), &data); err != nil {
+		t.Fatal(err)
+	}
+	if tags := data.Taxonomies["tags"]; len(tags) != 3 || tags[1].Name != "go" || tags[1].URL != "/docs/tags/go/" ||
+		len(tags[1].Pages) != 2 || tags[1].Pages[1].Name != "sub/b.md" || len(data.Taxonomies["categories"]) != 1 {
+		t.Errorf("unexpected taxonomies:\n%s", body)
+	}
+
+	// changed files are indexed again
+	writeDocs(t, dir, map[string]string{"c.md": "---\ntags: [new]\n---\n# Sea\n"})
+	os.Remove(filepath.Join(dir, "sub/b.md"))
+	for i := 0; i < 200; i++ {
+		if _, body = get("/tags/"); strings.Contains(body, "new") && !strings.Contains(body, "c&#43;&#43;") {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !strings.Contains(body, `<a href="/docs/tags/new/">new</a> (1)`) || strings.Contains(body, "c&#43;&#43;") {
+		t.Errorf("taxonomies not updated:\n%s", body)
+	}
+
+	// terms with a slash are listed at their escaped url
+	writeDocs(t, dir, map[string]string{"ci.md": "---\ntags: [ci/cd]\n---\n# Pipelines\n"})
+	h.(*handler).changed("ci.md")
+	if _, body := get("/tags/"); !strings.Contains(body, `<a href="/docs/tags/ci%2Fcd/">ci/cd</a> (1)`) {
+		t.Errorf("expected an escaped term url in:\n%s", body)
+	}
+	if code, body := get("/tags/ci%2Fcd/"); code != 200 || !strings.Contains(body, `<a href="/docs/ci.md">Pipelines</a>`) {
+		t.Errorf("/tags/ci%%2Fcd/: got %d:\n%s", code, body)
+	}
+
+	// taxonomies without a trailing slash are redirected
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/tags?x=1", nil))
+	if w.Code != 301 || w.Header().Get("Location") != "/docs/tags/?x=1" {
+		t.Errorf("/tags: got %d to %q", w.Code, w.Header().Get("Location"))
+	}
+}
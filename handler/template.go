@@ -0,0 +1,63 @@
+package handler
+
+import (
+	"html/template"
+	"io"
+	"net/http"
+)
+
+// page is the data available to header and footer templates
+type page struct {
+	Base string  // base path, empty when serving from "/"
+	Path string  // request path, without base path
+	Git  *Commit // last commit of the file, with Options.Git
+
+	Backlinks []Link                 // pages linking to this one, with Options.Backlinks
+	TOC       []Heading              // headings of a markdown page
+	Meta      map[string]interface{} // front matter of a markdown page, like .Meta.title
+	Site      map[string]interface{} // site variables, with Options.Shortcodes
+
+	pageNav // with Options.Nav
+}
+
+// parseTemplate parses a header or footer as a html template, nil if empty
+func parseTemplate(name string, text []byte) (*template.Template, error) {
+	if len(text) == 0 {
+		return nil, nil
+	}
+	return template.New(name).Parse(string(text))
+}
+
+// executeTemplate writes t to w, if t is not nil
+func (h *handler) executeTemplate(w io.Writer, t *template.Template, p page) {
+	if t == nil {
+		return
+	}
+	if err := t.Execute(w, p); err != nil {
+		h.Logger.Printf("error executing %s template: %v", t.Name(), err)
+	}
+}
+
+// servePage writes body between the header and footer templates
+func (h *handler) servePage(w http.ResponseWriter, r *http.Request, name string, body []byte) {
+	h.serveDocument(w, r, name, document{HTML: body}, http.StatusOK)
+}
+
+// serveDocument writes a rendered markdown page with status code, with its headings and front matter for templates
+func (h *handler) serveDocument(w http.ResponseWriter, r *http.Request, name string, doc document, code int) {
+	p := page{Base: h.BasePath, Path: r.URL.Path, Git: h.lastCommit(name), Backlinks: h.backlinks(name), TOC: doc.TOC, Meta: doc.Meta}
+	if h.Shortcodes != nil {
+		p.Site = h.Shortcodes.site
+	}
+	if h.Nav != "" {
+		p.pageNav = navFor(h.navItems(), name)
+	}
+	w.Header().Set("Content-Type", "text/html")
+	w.WriteHeader(code)
+	h.executeTemplate(w, h.header, p)
+	w.Write(doc.HTML)
+	h.executeTemplate(w, h.footer, p)
+	if h.LiveReload && r.Method == "GET" && !editQuery(r) {
+		reloadScript.Execute(w, struct{ Base, Name string }{h.BasePath, name})
+	}
+}
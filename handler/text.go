@@ -0,0 +1,158 @@
+package handler
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+// mapText calls f on the parts of markdown src outside of fenced code blocks and code spans,
+// and returns src with those parts replaced
+func mapText(src []byte, f func(text []byte) []byte) []byte {
+	var out, text bytes.Buffer
+	flush := func() {
+		out.Write(mapSpans(text.Bytes(), f))
+		text.Reset()
+	}
+	var fence []byte
+	for _, line := range bytes.SplitAfter(src, []byte("\n")) {
+		trimmed := bytes.TrimLeft(line, " ")
+		if fence != nil {
+			out.Write(line)
+			if closesFence(trimmed, fence) {
+				fence = nil
+			}
+			continue
+		}
+		if fence = opensFence(trimmed); fence != nil && len(line)-len(trimmed) < 4 {
+			flush()
+			out.Write(line)
+			continue
+		}
+		fence = nil
+		text.Write(line)
+	}
+	flush()
+	return out.Bytes()
+}
+
+// opensFence returns the ``` or ~~~ run starting a fenced code block, or nil
+func opensFence(line []byte) []byte {
+	if len(line) < 3 || (line[0] != '`' && line[0] != '~') {
+		return nil
+	}
+	n := bytes.IndexFunc(line, func(r rune) bool { return r != rune(line[0]) })
+	if n == -1 {
+		n = len(line)
+	}
+	if n < 3 {
+		return nil
+	}
+	return line[:n]
+}
+
+// closesFence returns true if line ends the code block opened by fence
+func closesFence(line, fence []byte) bool {
+	run := opensFence(line)
+	return run != nil && run[0] == fence[0] && len(run) >= len(fence) &&
+		len(bytes.TrimSpace(line[len(run):])) == 0
+}
+
+// mapSpans calls f on the parts of text outside of `code spans`
+func mapSpans(text []byte, f func(text []byte) []byte) []byte {
+	var out []byte
+	start := 0
+	for i := 0; i < len(text); {
+		if text[i] != '`' {
+			i++
+			continue
+		}
+		n := backticks(text[i:])
+		end := -1
+		for j := i + n; j < len(text); {
+			if text[j] != '`' {
+				j++
+				continue
+			}
+			m := backticks(text[j:])
+			if m == n {
+				end = j + m
+				break
+			}
+			j += m
+		}
+		if end == -1 {
+			i += n
+			continue
+		}
+		out = append(out, f(text[start:i])...)
+		out = append(out, text[i:end]...)
+		i, start = end, end
+	}
+	return append(out, f(text[start:])...)
+}
+
+// backticks counts the backticks at the start of b
+func backticks(b []byte) int {
+	n := 0
+	for n < len(b) && b[n] == '`' {
+		n++
+	}
+	return n
+}
+
+// placeholders keep generated html away from the markdown renderer and the sanitizer.
+// tokens are letters and digits only, so markdown leaves them alone.
+type placeholders struct {
+	prefix string
+	html   [][]byte
+	block  []bool // replaces a paragraph of its own
+	re     *regexp.Regexp
+}
+
+func newPlaceholders() *placeholders {
+	nonce := make([]byte, 6)
+	rand.Read(nonce)
+	prefix := "mdph" + hex.EncodeToString(nonce) + "x"
+	return &placeholders{prefix: prefix, re: regexp.MustCompile(`(<p>)?` + prefix + `(\d+)x(</p>)?|<[^>]*>`)}
+}
+
+// add returns a token that is replaced by html after rendering
+func (p *placeholders) add(html []byte) []byte {
+	p.html = append(p.html, html)
+	p.block = append(p.block, false)
+	return []byte(fmt.Sprintf("%s%dx", p.prefix, len(p.html)-1))
+}
+
+// addBlock returns a token for block html, which is not wrapped in a paragraph
+// when the token is alone in one
+func (p *placeholders) addBlock(html []byte) []byte {
+	token := p.add(html)
+	p.block[len(p.block)-1] = true
+	return token
+}
+
+// replace every token in b with its html.
+// tokens in tags, like heading anchors made from their text, are removed.
+func (p *placeholders) replace(b []byte) []byte {
+	if len(p.html) == 0 {
+		return b
+	}
+	return p.re.ReplaceAllFunc(b, func(token []byte) []byte {
+		m := p.re.FindSubmatch(token)
+		if m[2] == nil {
+			return append([]byte("<"), p.re.ReplaceAll(token[1:], nil)...)
+		}
+		i, err := strconv.Atoi(string(m[2]))
+		if err != nil || i >= len(p.html) {
+			return token
+		}
+		if p.block[i] && len(m[1]) > 0 && len(m[3]) > 0 {
+			return p.html[i]
+		}
+		return append(append(append([]byte{}, m[1]...), p.html[i]...), m[3]...)
+	})
+}
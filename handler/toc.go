@@ -0,0 +1,124 @@
+package handler
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// Heading is an entry in the table of contents of a page, available to templates as .TOC
+type Heading struct {
+	Level int    // 1 for h1, to 6
+	Text  string // text content of the heading
+	ID    string // anchor, link to it with "#"+ID
+}
+
+// default deepest heading level in a table of contents
+const defaultTOCDepth = 3
+
+// [TOC] on a line of its own is replaced by the table of contents
+var tocMarkerRegexp = regexp.MustCompile(`(?m)^[ \t]*\[TOC\][ \t]*$`)
+
+// tocMarker replaces [TOC] lines outside of code with a paragraph holding token
+func tocMarker(src, token []byte) ([]byte, bool) {
+	found := false
+	src = mapText(src, func(text []byte) []byte {
+		return tocMarkerRegexp.ReplaceAllFunc(text, func([]byte) []byte {
+			found = true
+			return append(append([]byte("\n"), token...), '\n')
+		})
+	})
+	return src, found
+}
+
+// headings returns the headings with anchors in rendered html, down to level depth
+func headings(b []byte, depth int) []Heading {
+	var list []Heading
+	var cur *Heading
+	var text strings.Builder
+	annotation := false // tex source of math formulas
+	z := html.NewTokenizer(bytes.NewReader(b))
+	for {
+		tt := z.Next()
+		switch tt {
+		case html.ErrorToken:
+			return list
+		case html.StartTagToken, html.SelfClosingTagToken:
+			tag, hasAttr := z.TagName()
+			annotation = annotation || string(tag) == "annotation"
+			if level := headingLevel(tag); level != 0 {
+				cur = &Heading{Level: level}
+				text.Reset()
+			}
+			if cur == nil {
+				continue
+			}
+			// gfm headings hold an anchor, plain ones have an id
+			for hasAttr {
+				var key, val []byte
+				key, val, hasAttr = z.TagAttr()
+				if cur.ID == "" && (string(key) == "id" || string(key) == "name" && string(tag) == "a") {
+					cur.ID = string(val)
+				}
+			}
+		case html.TextToken:
+			if cur != nil && !annotation {
+				text.Write(z.Text())
+			}
+		case html.EndTagToken:
+			tag, _ := z.TagName()
+			if string(tag) == "annotation" {
+				annotation = false
+			}
+			if cur == nil || headingLevel(tag) != cur.Level {
+				continue
+			}
+			cur.Text = strings.TrimSpace(text.String())
+			if cur.ID != "" && cur.Level <= depth {
+				list = append(list, *cur)
+			}
+			cur = nil
+		}
+	}
+}
+
+// headingLevel returns 1 to 6 for h1 to h6, or 0
+func headingLevel(tag []byte) int {
+	if len(tag) == 2 && tag[0] == 'h' && tag[1] >= '1' && tag[1] <= '6' {
+		return int(tag[1] - '0')
+	}
+	return 0
+}
+
+// tocHTML renders a table of contents as nested lists
+func tocHTML(toc []Heading) []byte {
+	if len(toc) == 0 {
+		return nil
+	}
+	var buf bytes.Buffer
+	buf.WriteString(`<nav class="toc">` + "\n<ul>\n")
+	levels := []int{toc[0].Level} // of the open lists
+	for i, hd := range toc {
+		switch {
+		case i == 0:
+		case hd.Level > levels[len(levels)-1]:
+			buf.WriteString("\n<ul>\n")
+			levels = append(levels, hd.Level)
+		default:
+			for len(levels) > 1 && hd.Level < levels[len(levels)-1] {
+				buf.WriteString("</li>\n</ul>\n")
+				levels = levels[:len(levels)-1]
+			}
+			buf.WriteString("</li>\n")
+		}
+		fmt.Fprintf(&buf, `<li><a href="#%s">%s</a>`, html.EscapeString(hd.ID), html.EscapeString(hd.Text))
+	}
+	for range levels {
+		buf.WriteString("</li>\n</ul>\n")
+	}
+	buf.WriteString("</nav>\n")
+	return buf.Bytes()
+}
@@ -0,0 +1,59 @@
+package handler
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestTOC(t *testing.T) {
+	src := []byte("# Title\n\n[TOC]\n\n## One & two\n\n### Deep\n\n#### Deeper\n\n## Three\n\n```\n[TOC]\n```\n")
+	for _, isPlain := range []bool{false, true} {
+		h := newHandler(t, Options{Plain: isPlain}).(*handler)
+		doc := h.renderPage("", src)
+		out, toc := doc.HTML, doc.TOC
+		var got []string
+		for _, hd := range toc {
+			got = append(got, strings.Repeat("#", hd.Level)+" "+hd.Text+" "+hd.ID)
+		}
+		if want := "# Title title|## One & two one-two|### Deep deep|## Three three"; strings.Join(got, "|") != want {
+			t.Errorf("plain=%v: expected %q, got %q", isPlain, want, strings.Join(got, "|"))
+		}
+		nav := `<nav class="toc">
+<ul>
+<li><a href="#title">Title</a>
+<ul>
+<li><a href="#one-two">One &amp; two</a>
+<ul>
+<li><a href="#deep">Deep</a></li>
+</ul>
+</li>
+<li><a href="#three">Three</a></li>
+</ul>
+</li>
+</ul>
+</nav>
+`
+		if !strings.Contains(string(out), nav) {
+			t.Errorf("plain=%v: expected toc at the marker, got %q", isPlain, out)
+		}
+		if strings.Count(string(out), "[TOC]") != 1 {
+			t.Errorf("plain=%v: expected marker in code to stay, got %q", isPlain, out)
+		}
+	}
+
+	// at the top with TOC, with depth
+	h := newHandler(t, Options{TOC: true, TOCDepth: 1}).(*handler)
+	out := h.renderPage("", []byte("# A\n\n## B\n")).HTML
+	if !strings.HasPrefix(string(out), `<nav class="toc">`+"\n<ul>\n"+`<li><a href="#a">A</a></li>`+"\n</ul>\n</nav>\n") {
+		t.Errorf("expected toc at the top, got %q", out)
+	}
+
+	// in templates
+	h = newHandler(t, Options{Root: DirFS(docsDir(t)), Index: "index.md", Header: []byte(`{{range .TOC}}<a href="#{{.ID}}" class="h{{.Level}}">{{.Text}}</a>{{end}}`)}).(*handler)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if body := w.Body.String(); !strings.Contains(body, `class="h1">`) {
+		t.Errorf("expected headings in header template, got %q", body)
+	}
+}
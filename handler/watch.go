@@ -0,0 +1,68 @@
+package handler
+
+import (
+	"io/fs"
+	"time"
+)
+
+// fileState tells if a file was modified
+type fileState struct {
+	modtime int64
+	size    int64
+}
+
+// snapshot returns the state of every file that can be served
+func (h *handler) snapshot() map[string]fileState {
+	files := make(map[string]fileState)
+	fs.WalkDir(h.Root, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if name != "." && !h.visible(name) {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		if fi, err := d.Info(); err == nil {
+			files[name] = fileState{modtime: fi.ModTime().UnixNano(), size: fi.Size()}
+		}
+		return nil
+	})
+	return files
+}
+
+// watch polls Root every interval, for files changed outside of the editor and webdav,
+// until stop is closed. old is the snapshot taken when the handler was created.
+func (h *handler) watch(interval time.Duration, old map[string]fileState, stop chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+		files := h.snapshot()
+		for name, state := range files {
+			if prev, ok := old[name]; !ok || prev != state {
+				h.changed(name)
+			}
+		}
+		for name := range old {
+			if _, ok := files[name]; !ok {
+				h.changed(name)
+			}
+		}
+		old = files
+	}
+}
+
+// Close stops polling Root for changes, see Options.Watch
+func (h *handler) Close() error {
+	h.stopOnce.Do(func() { close(h.stop) })
+	return nil
+}
@@ -0,0 +1,117 @@
+package handler
+
+import (
+	"fmt"
+	"html/template"
+	"io/fs"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/shurcooL/sanitized_anchor_name"
+)
+
+// SlugFunc turns a wiki page title into a file name, the .md extension is added if missing
+type SlugFunc func(title string) string
+
+// Slug returns the slug rule named rule:
+// "kebab" for page-name.md, "underscore" for Page_Name.md, or "keep" for Page Name.md
+func Slug(rule string) (SlugFunc, error) {
+	switch rule {
+	case "kebab":
+		return kebabSlug, nil
+	case "underscore":
+		return func(title string) string {
+			return strings.Join(strings.Fields(title), "_")
+		}, nil
+	case "keep":
+		return strings.TrimSpace, nil
+	default:
+		return nil, fmt.Errorf("unknown wiki slug rule: %q, try 'kebab', 'underscore', or 'keep'", rule)
+	}
+}
+
+// kebabSlug lowercases title, and joins its words with '-'
+func kebabSlug(title string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.TrimSpace(title) {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r) || r == '.':
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			dash = false
+			b.WriteRune(unicode.ToLower(r))
+		default:
+			dash = true
+		}
+	}
+	return b.String()
+}
+
+// [[Page Name]], [[Page Name|label]], and [[Page Name#Section]]
+var wikiLinkRegexp = regexp.MustCompile(`\[\[([^\[\]|\n]+)(?:\|([^\[\]\n]+))?\]\]`)
+
+// wikiTarget returns the file name and anchor of a wiki link to title
+func (h *handler) wikiTarget(title string) (name, anchor string, ok bool) {
+	if i := strings.Index(title, "#"); i != -1 {
+		title, anchor = title[:i], sanitized_anchor_name.Create(title[i+1:])
+	}
+	var elems []string
+	for _, elem := range strings.Split(title, "/") {
+		if strings.TrimSpace(elem) == "" {
+			continue
+		}
+		slug := h.Wiki(elem)
+		if slug == "" || strings.HasPrefix(slug, ".") || strings.Contains(slug, "/") {
+			return "", "", false
+		}
+		elems = append(elems, slug)
+	}
+	if len(elems) == 0 {
+		return "", "", false
+	}
+	name = path.Join(elems...)
+	if !strings.HasSuffix(name, ".md") {
+		name += ".md"
+	}
+	return name, anchor, fs.ValidPath(name)
+}
+
+// wikiLinks replaces [[links]] with placeholders for links to pages in Root.
+// links to missing pages have the "wiki-missing" class, and open the editor with Edit.
+func (h *handler) wikiLinks(src []byte, ph *placeholders) []byte {
+	return mapText(src, func(text []byte) []byte {
+		return wikiLinkRegexp.ReplaceAllFunc(text, func(link []byte) []byte {
+			m := wikiLinkRegexp.FindSubmatch(link)
+			title, label := string(m[1]), strings.TrimSpace(string(m[2]))
+			if label == "" {
+				label = strings.TrimSpace(title)
+			}
+			name, anchor, ok := h.wikiTarget(title)
+			if !ok {
+				return link
+			}
+			u := url.URL{Path: h.BasePath + "/" + name, Fragment: anchor}
+			class := "wiki"
+			if !h.exists(name) {
+				class += " wiki-missing"
+				u.Fragment = ""
+				if h.Edit {
+					u.RawQuery = "edit"
+				}
+			}
+			return ph.add([]byte(fmt.Sprintf(`<a class="%s" href="%s">%s</a>`,
+				class, template.HTMLEscapeString(u.String()), template.HTMLEscapeString(label))))
+		})
+	})
+}
+
+// exists returns true if name is a file that can be served
+func (h *handler) exists(name string) bool {
+	fi, err := fs.Stat(h.Root, name)
+	return err == nil && !fi.IsDir() && h.fileisgood(name)
+}
@@ -0,0 +1,90 @@
+package handler
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSlug(t *testing.T) {
+	for _, tc := range []struct{ rule, title, want string }{
+		{"kebab", "Page Name", "page-name"},
+		{"kebab", "  What's New?  ", "what-s-new"},
+		{"kebab", "Ünïcode Page", "ünïcode-page"},
+		{"kebab", "notes.md", "notes.md"},
+		{"underscore", "Page  Name", "Page_Name"},
+		{"keep", " Page Name ", "Page Name"},
+	} {
+		slug, err := Slug(tc.rule)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := slug(tc.title); got != tc.want {
+			t.Errorf("%s %q: expected %q, got %q", tc.rule, tc.title, tc.want, got)
+		}
+	}
+	if _, err := Slug("camel"); err == nil {
+		t.Error("expected error for unknown rule")
+	}
+}
+
+func TestMapText(t *testing.T) {
+	src := "a `b` c\n```\nd\n```\n``e ` f`` g\n    ~~~~\n"
+	var got []string
+	mapText([]byte(src), func(text []byte) []byte {
+		got = append(got, string(text))
+		return text
+	})
+	want := []string{"a ", " c\n", "", " g\n    ~~~~\n"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+}
+
+func TestWikiLinks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "markdownd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.Mkdir(filepath.Join(dir, "guides"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "home-page.md"), []byte("# Home\n"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "guides", "getting-started.md"), []byte("# Start\n"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "index.md"), []byte(strings.Join([]string{
+		"See [[Home Page]], [[guides/Getting Started|the guide]] and [[Home Page#Some Section]].",
+		"",
+		"[[Missing Page]] and [[../../etc/passwd]] and `[[In Code]]`",
+		"",
+		"```",
+		"[[In Fence]]",
+		"```",
+	}, "\n")), 0644)
+	slug, _ := Slug("kebab")
+	strict, _ := NewSanitizer("strict")
+
+	for _, opts := range []Options{
+		{Root: DirFS(dir), Wiki: slug, BasePath: "/wiki", Edit: true, Auth: &Users{}},
+		{Root: DirFS(dir), Wiki: slug, BasePath: "/wiki", Edit: true, Auth: &Users{}, Plain: true, Sanitizer: strict},
+	} {
+		h := newHandler(t, opts)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/index.md", nil))
+		body := w.Body.String()
+		for _, want := range []string{
+			`<a class="wiki" href="/wiki/home-page.md">Home Page</a>`,
+			`<a class="wiki" href="/wiki/guides/getting-started.md">the guide</a>`,
+			`<a class="wiki" href="/wiki/home-page.md#some-section">Home Page#Some Section</a>`,
+			`<a class="wiki wiki-missing" href="/wiki/missing-page.md?edit">Missing Page</a>`,
+			`[[../../etc/passwd]]`,
+			`[[In Code]]`,
+			`[[In Fence]]`,
+		} {
+			if !strings.Contains(body, want) {
+				t.Errorf("plain=%v: expected %q in %s", opts.Plain, want, body)
+			}
+		}
+	}
+}
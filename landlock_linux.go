@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"syscall"
+	"unsafe"
+)
+
+// landlock syscalls and constants, see linux/landlock.h
+const (
+	sysLandlockCreateRuleset = 444
+	sysLandlockAddRule       = 445
+	sysLandlockRestrictSelf  = 446
+
+	landlockCreateRulesetVersion = 1 << 0
+	landlockRulePathBeneath      = 1
+
+	landlockAccessFSWriteFile  = 1 << 1
+	landlockAccessFSReadFile   = 1 << 2
+	landlockAccessFSReadDir    = 1 << 3
+	landlockAccessFSRemoveDir  = 1 << 4
+	landlockAccessFSRemoveFile = 1 << 5
+	landlockAccessFSMakeDir    = 1 << 7
+	landlockAccessFSMakeReg    = 1 << 8
+
+	// every filesystem access right of landlock ABI version 1
+	landlockAccessFSv1 = 1<<13 - 1
+
+	prSetNoNewPrivs = 38
+	oPath           = 0x200000
+)
+
+type landlockRulesetAttr struct {
+	handledAccessFS uint64
+}
+
+// packed in the kernel, the trailing padding of this struct is not read
+type landlockPathBeneathAttr struct {
+	allowedAccess uint64
+	parentFd      int32
+}
+
+// landlock only allows reading files beneath dir, for every thread of the process.
+// with write, files and directories beneath dir can also be changed, for the editor and webdav.
+func landlock(dir string, write bool) error {
+	abi, _, errno := syscall.Syscall(sysLandlockCreateRuleset, 0, 0, landlockCreateRulesetVersion)
+	if errno != 0 {
+		return fmt.Errorf("not supported by this kernel: %v", errno)
+	}
+	if int(abi) < 1 {
+		return fmt.Errorf("unknown abi version %d", abi)
+	}
+
+	attr := landlockRulesetAttr{handledAccessFS: landlockAccessFSv1}
+	fd, _, errno := syscall.Syscall(sysLandlockCreateRuleset, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr), 0)
+	if errno != 0 {
+		return fmt.Errorf("create ruleset: %v", errno)
+	}
+	defer syscall.Close(int(fd))
+
+	parent, err := syscall.Open(dir, oPath|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return err
+	}
+	defer syscall.Close(parent)
+
+	rule := landlockPathBeneathAttr{
+		allowedAccess: landlockAccessFSReadFile | landlockAccessFSReadDir,
+		parentFd:      int32(parent),
+	}
+	if write {
+		rule.allowedAccess |= landlockAccessFSWriteFile | landlockAccessFSMakeReg | landlockAccessFSRemoveFile |
+			landlockAccessFSMakeDir | landlockAccessFSRemoveDir
+	}
+	_, _, errno = syscall.Syscall6(sysLandlockAddRule, fd, landlockRulePathBeneath, uintptr(unsafe.Pointer(&rule)), 0, 0, 0)
+	if errno != 0 {
+		return fmt.Errorf("add rule: %v", errno)
+	}
+
+	// restrict every thread, not only the current one
+	if _, _, errno = syscall.AllThreadsSyscall(syscall.SYS_PRCTL, prSetNoNewPrivs, 1, 0); errno != 0 {
+		return fmt.Errorf("no_new_privs: %v (build with CGO_ENABLED=0)", errno)
+	}
+	if _, _, errno = syscall.AllThreadsSyscall(sysLandlockRestrictSelf, fd, 0, 0); errno != 0 {
+		return fmt.Errorf("restrict self: %v", errno)
+	}
+	return nil
+}
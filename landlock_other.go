@@ -0,0 +1,10 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import "errors"
+
+func landlock(dir string, write bool) error {
+	return errors.New("only available on linux")
+}
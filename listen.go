@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// first file descriptor passed by systemd socket activation
+const listenFdsStart = 3
+
+// listen on addr, which is either "unix:/path/to.sock" or a tcp "address:port".
+// when started by systemd socket activation, the passed socket is used instead.
+func listen(addr string, socketMode os.FileMode) (net.Listener, error) {
+	if l, err := systemdListener(); l != nil || err != nil {
+		return l, err
+	}
+
+	if !strings.HasPrefix(addr, "unix:") {
+		return net.Listen("tcp", addr)
+	}
+
+	sockpath := strings.TrimPrefix(addr, "unix:")
+
+	// remove stale socket from previous run, but nothing else
+	if fi, err := os.Lstat(sockpath); err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", sockpath)
+		}
+		if err := os.Remove(sockpath); err != nil {
+			return nil, err
+		}
+	}
+
+	l, err := net.Listen("unix", sockpath)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(sockpath, socketMode); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// systemdListener returns the socket passed with LISTEN_FDS, if any
+func systemdListener() (net.Listener, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	nfds, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || nfds < 1 {
+		return nil, nil
+	}
+
+	// dont pass sockets to child processes
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	if nfds > 1 {
+		logger.Printf("systemd passed %d sockets, only using the first one", nfds)
+	}
+	f := os.NewFile(listenFdsStart, "systemd socket")
+	defer f.Close()
+	l, err := net.FileListener(f)
+	if err != nil {
+		return nil, fmt.Errorf("systemd socket activation: %v", err)
+	}
+	return l, nil
+}
+
+// parseSocketMode parses an octal file mode like "0660"
+func parseSocketMode(s string) (os.FileMode, error) {
+	mode, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || mode > 0777 {
+		return 0, fmt.Errorf("bad socket mode: %q", s)
+	}
+	return os.FileMode(mode), nil
+}
+
+// connKey is the context key of the connection of a request, see withConn
+type connKey struct{}
+
+// withConn adds the connection to the context of its requests, for http.Server.ConnContext
+func withConn(ctx context.Context, c net.Conn) context.Context {
+	return context.WithValue(ctx, connKey{}, c)
+}
+
+// stream lets requests for path, like the live reload stream, outlive the server write timeout.
+// every other request keeps the timeout.
+type stream struct {
+	path string
+	next http.Handler
+}
+
+func (s stream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == s.path {
+		if c, ok := r.Context().Value(connKey{}).(net.Conn); ok {
+			c.SetWriteDeadline(time.Time{})
+		}
+	}
+	s.next.ServeHTTP(w, r)
+}
@@ -28,32 +28,224 @@ package main
 import (
 	"flag"
 	"fmt"
+	"io/fs"
 	"io/ioutil"
 	"log"
 	"math/rand"
 	"net/http"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"time"
 
-	"github.com/russross/blackfriday"
-	"github.com/shurcooL/github_flavored_markdown"
-	"github.com/sourcegraph/syntaxhighlight"
+	"github.com/aerth/markdownd/handler"
 )
 
 // flags
 var (
-	addr          = flag.String("http", "127.0.0.1:8080", "address to listen on format 'address:port',\n\tif address is omitted will listen on all interfaces")
+	addr          = flag.String("http", "127.0.0.1:8080", "address to listen on format 'address:port',\n\tif address is omitted will listen on all interfaces,\n\tuse 'unix:/path/to.sock' for a unix domain socket")
+	socketMode    = flag.String("socket-mode", "0660", "file permissions of the '-http unix:' socket")
 	logfile       = flag.String("log", os.Stderr.Name(), "redirect logs to this file")
 	indexPage     = flag.String("index", "index.md", "filename to use for paths ending in '/',\n\ttry something like '-index=README.md' or '-index=gen' to generate a simple one.")
-	header        = flag.String("header", "", "html header filename for markdown requests")
-	footer        = flag.String("footer", "", "html footer filename for markdown requests")
-	toc           = flag.Bool("toc", false, "generate table of contents at the top of each markdown page")
+	header        = flag.String("header", "", "html header template filename for markdown requests")
+	footer        = flag.String("footer", "", "html footer template filename for markdown requests")
+	toc           = flag.Bool("toc", false, "generate table of contents at the top of each markdown page,\n\tpages can also place it with a '[TOC]' line")
+	tocDepth      = flag.Int("toc-depth", 3, "deepest heading level in tables of contents, 1 to 6")
+	mathEnabled   = flag.Bool("math", false, "render $inline$ and $$display$$ LaTeX formulas to MathML,\n\tpages can turn it on or off with 'math: true' front matter")
+	diagrams      = flag.Bool("diagrams", false, "render mermaid, dot, graphviz and plantuml code blocks to svg,\n\twith mmdc, dot and plantuml commands")
+	alerts        = flag.Bool("alerts", false, "render github alerts, blockquotes starting with a line like '> [!NOTE]'")
+	footnotes     = flag.Bool("footnotes", false, "render [^1] footnotes, with links back to the references")
+	deflists      = flag.Bool("definition-lists", false, "render a 'Term' line followed by ': definition' lines as a definition list")
+	emoji         = flag.Bool("emoji", false, "replace github emoji shortcodes like ':tada:'")
+	includes      = flag.Bool("includes", false, "expand '{{< include \"file.md\" >}}' and '{{< snippet \"file.go\" region=\"name\" >}}' in pages,\n\tfrom files relative to the page")
 	plain         = flag.Bool("plain", false, "disable github flavored markdown")
+	nav           = flag.String("nav", "", "file ordering pages into a navigation, an mdBook 'SUMMARY.md' or a yaml list like 'nav.yaml',\n\tfor '{{.Nav}}', '{{.Breadcrumbs}}', '{{.Prev}}' and '{{.Next}}' in templates")
+	taxonomies    = flag.String("taxonomies", "", "comma separated front matter fields to list pages by, like 'tags,categories',\n\tat '/tags/' and '/tags/name/', and as json at '/_api/taxonomies'")
+	blog          = flag.String("blog", "", "directory of dated posts, '.' for all of them, listed newest first at its url with pages like 'page/2/',\n\tfeeds at '/feed.atom', '/feed.rss' and '/feed.json', and their tags at '/tags/name/' like '-taxonomies tags'")
+	blogTitle     = flag.String("blog-title", "", "title of '-blog' listing pages and feeds, defaults to the title of the index page of its directory")
+	blogPageSize  = flag.Int("blog-page-size", 10, "posts per '-blog' listing page")
+	siteURL       = flag.String("site-url", "", "scheme and host of the site for absolute urls in feeds, like 'https://example.com',\n\tdefaults to the Host and scheme of requests from '-trusted-proxies', feeds are not served without either")
+	shortcodes    = flag.Bool("shortcodes", false, "expand '{{< name >}}' shortcodes and '{{ .Site.Name }}' variables in pages,\n\twith the built-in ref, relref, figure, youtube and details shortcodes")
+	shortcodeDir  = flag.String("shortcode-dir", "", "directory of html templates of more shortcodes, like 'button.html' for '{{< button >}}',\n\timplies '-shortcodes'")
+	extensions    = flag.String("extensions", "", "markdown extensions and html flags replacing the defaults, a comma separated list of presets\n\t'gfm', 'commonmark', 'minimal' and 'strict', and names like 'tables' or '-autolink' to remove one,\n\t'help' lists them")
+	configFile    = flag.String("config", "", "yaml file of flag names and values, like 'toc: true', overridden by flags")
 	syntaxEnabled = flag.Bool("syntax", false, "highlight syntax in .html")
+	rateMarkdown  = flag.Float64("rate", 0, "rendered markdown requests per second allowed per client, 0 for unlimited")
+	rateStatic    = flag.Float64("rate-static", 0, "static file requests per second allowed per client, 0 for unlimited")
+	rateBurst     = flag.Int("burst", 10, "requests a client can make at once before being rate limited")
+	maxRequests   = flag.Int("max-requests", 0, "maximum concurrent requests, 0 for unlimited")
+	proxies       = flag.String("trusted-proxies", "", "comma separated addresses or networks of reverse proxies\n\tallowed to set Forwarded, X-Forwarded-For and X-Forwarded-Proto, 'unix' for unix socket peers")
+	runUser       = flag.String("user", "", "drop privileges to this user after binding the listener")
+	runGroup      = flag.String("group", "", "drop privileges to this group after binding the listener,\n\tdefaults to the primary group of '-user'")
+	sandboxMode   = flag.String("sandbox", "none", "restrict filesystem access to the served directory after binding the listener:\n\t'chroot' (needs root) or 'landlock' (linux 5.13+)")
+	archives      = flag.Bool("archives", false, "browse zip and tar files like directories, at '/name.zip/'")
+	gitRef        = flag.String("git-ref", "", "serve the directory as it is in this git revision, like 'v1.0' or 'main',\n\tfrom the repository object store instead of the work tree")
+	gitRefs       = flag.Bool("git-refs", false, "serve any git revision for requests like '/README.md?ref=v1.0'")
+	edit          = flag.Bool("edit", false, "edit markdown files in the browser at '/name.md?edit', needs '-users'")
+	usersFile     = flag.String("users", "", "htpasswd file of editors, with bcrypt hashes ('htpasswd -B')")
+	dav           = flag.Bool("dav", false, "serve the directory over webdav at '/_dav/', needs '-users'")
+	liveReload    = flag.Bool("live-reload", false, "reload open pages when their file is changed by the editor or webdav")
+	wiki          = flag.String("wiki", "", "resolve [[Page Name]] links to files named by 'kebab' (page-name.md),\n\t'underscore' (Page_Name.md), or 'keep' (Page Name.md)")
+	backlinks     = flag.Bool("backlinks", false, "index links between pages, for '{{.Backlinks}}' in templates and '/_api/links'")
+	brokenLinks   = flag.Bool("broken-links", false, "count 404 responses and the pages linking to them, at '/_admin/broken-links',\n\tbehind the '-users' login, which it needs")
+	watch         = flag.Duration("watch", 0, "poll the directory for changed files this often, like '2s',\n\tfor '-live-reload', '-backlinks', '-taxonomies' and '-blog', which default to 2s, '-watch 0' turns it off")
+	dotfiles      = flag.Bool("dotfiles", false, "allow names starting with '.', like '.git', over webdav, in the editor and includes,\n\tinstead of refusing them")
+	editCommit    = flag.Bool("edit-commit", false, "commit saved files to git as the editor, needs '-git'")
+	gitInfo       = flag.Bool("git", false, "add the last commit of each page to templates as '{{.Git}}',\n\tand serve '?history' and '?diff=<rev>' views of files")
+	basePath      = flag.String("base-path", "", "url path prefix when served behind a reverse proxy, like '/docs/'")
+	sanitize      = flag.String("sanitize", "ugc", "html sanitization policy for rendered markdown: 'ugc', 'strict' (drop raw html),\n\t'none' (trusted content only), or path to an allowlist file")
 )
 
+// commands for diagram languages, from -diagram lang=command
+var diagramCommands = diagramFlag{}
+
+// site variables, from -site name=value
+var siteVariables = siteFlag{}
+
+type siteFlag map[string]interface{}
+
+func (s siteFlag) String() string {
+	var list []string
+	for name, value := range s {
+		list = append(list, fmt.Sprintf("%s=%v", name, value))
+	}
+	sort.Strings(list)
+	return strings.Join(list, ", ")
+}
+
+func (s siteFlag) Set(v string) error {
+	i := strings.IndexByte(v, '=')
+	if i < 1 {
+		return fmt.Errorf("want name=value, got %q", v)
+	}
+	s[v[:i]] = v[i+1:]
+	return nil
+}
+
+type diagramFlag map[string][]string
+
+func (d diagramFlag) String() string {
+	var list []string
+	for lang, command := range d {
+		list = append(list, lang+"="+strings.Join(command, " "))
+	}
+	sort.Strings(list)
+	return strings.Join(list, ", ")
+}
+
+func (d diagramFlag) Set(s string) error {
+	i := strings.IndexByte(s, '=')
+	if i < 1 || len(strings.Fields(s[i+1:])) == 0 {
+		return fmt.Errorf("want lang=command, got %q", s)
+	}
+	d[s[:i]] = strings.Fields(s[i+1:])
+	return nil
+}
+
+// markdownExtensions returns the -extensions, or nil for the defaults
+func markdownExtensions() *handler.Extensions {
+	if *extensions == "" {
+		return nil
+	}
+	e, err := handler.NewExtensions(*extensions)
+	if err != nil {
+		println(err.Error())
+		os.Exit(111)
+	}
+	return e
+}
+
+// siteShortcodes returns the shortcodes, or nil without -shortcodes
+func siteShortcodes() *handler.Shortcodes {
+	if !*shortcodes && *shortcodeDir == "" {
+		return nil
+	}
+	s, err := handler.NewShortcodes(siteVariables, *shortcodeDir)
+	if err != nil {
+		println(err.Error())
+		os.Exit(111)
+	}
+	return s
+}
+
+// blogOptions returns the blog of the -blog flags, or nil
+func blogOptions() *handler.Blog {
+	if *blog == "" {
+		return nil
+	}
+	return &handler.Blog{Dir: *blog, Title: *blogTitle, URL: *siteURL, PageSize: *blogPageSize}
+}
+
+// taxonomyFields returns the front matter fields of -taxonomies
+func taxonomyFields() []string {
+	var fields []string
+	for _, field := range strings.Split(*taxonomies, ",") {
+		if field = strings.TrimSpace(field); field != "" {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+// watchInterval returns -watch, or 2s to keep the indexes of -backlinks, -taxonomies and -blog tags up to date
+func watchInterval() time.Duration {
+	set := false
+	flag.Visit(func(f *flag.Flag) { set = set || f.Name == "watch" })
+	if !set && (*backlinks || *taxonomies != "" || *blog != "") {
+		return 2 * time.Second
+	}
+	return *watch
+}
+
+// markdownOptions returns the handler options shared by serve and check,
+// for pages rendered and generated the same way by both
+func markdownOptions() handler.Options {
+	opts := handler.Options{
+		Index:           *indexPage,
+		GenerateIndex:   *indexPage == "gen",
+		Plain:           *plain,
+		TOC:             *toc,
+		TOCDepth:        *tocDepth,
+		Math:            *mathEnabled,
+		Alerts:          *alerts,
+		Footnotes:       *footnotes,
+		DefinitionLists: *deflists,
+		Emoji:           *emoji,
+		Includes:        *includes,
+		Nav:             *nav,
+		Shortcodes:      siteShortcodes(),
+		Extensions:      markdownExtensions(),
+		Taxonomies:      taxonomyFields(),
+		Blog:            blogOptions(),
+		Archives:        *archives,
+		Dotfiles:        *dotfiles,
+		BasePath:        *basePath,
+	}
+	policy, err := handler.NewSanitizer(*sanitize)
+	if err != nil {
+		println(err.Error())
+		os.Exit(111)
+	}
+	opts.Sanitizer = policy
+	if *diagrams || len(diagramCommands) > 0 {
+		opts.Diagrams = make(map[string][]string)
+		if *diagrams {
+			opts.Diagrams = handler.DefaultDiagrams()
+		}
+		for lang, command := range diagramCommands {
+			opts.Diagrams[lang] = command
+		}
+	}
+	if *wiki != "" {
+		if opts.Wiki, err = handler.Slug(*wiki); err != nil {
+			println(err.Error())
+			os.Exit(111)
+		}
+	}
+	return opts
+}
+
 // log to file
 var logger = log.New(os.Stderr, "[markdownd] ", log.LstdFlags)
 
@@ -63,7 +255,8 @@ const serverheader = "markdownd/" + version
 const usage = `
 USAGE
 
-markdownd [flags] [directory]
+markdownd [flags] [directory or archive]
+markdownd check [flags] [directory or archive]
 
 EXAMPLES
 
@@ -81,31 +274,65 @@ Serve docs with header, footer, and table of contents. Disable Logs:
 
 Serve docs only on localhost:
 	markdownd -http 127.0.0.1:8080 docs
+
+Serve docs on a unix socket, for a local reverse proxy:
+	markdownd -http unix:/run/markdownd.sock -socket-mode 0660 -trusted-proxies unix docs
+
+Serve docs behind a local reverse proxy at '/docs/', 2 rendered pages per second per client:
+	markdownd -trusted-proxies 127.0.0.1 -base-path /docs/ -rate 2 -rate-static 20 docs
+
+Serve docs on port 80, then run as 'nobody' inside a chroot:
+	sudo markdownd -http :80 -user nobody -sandbox chroot docs
+
+Serve a documentation bundle without extracting it:
+	markdownd -index README.md docs-v1.2.zip
+
+Serve trusted docs, allowing extra html elements listed in 'allow.txt':
+	markdownd -sanitize allow.txt docs
+
+Report broken links, anchors and images in docs, exiting 1 if there are any:
+	markdownd check -index README.md docs
+
+Serve docs rendered like other commonmark tools, with flags from a config file:
+	markdownd -config markdownd.yaml -extensions commonmark,tables docs
 FLAGS
 `
 
 // redefine flag Usage
 func init() {
+	flag.Var(siteVariables, "site", "site variable for '{{ .Site.Name }}' in pages and templates, like 'Version=1.2', repeatable")
+	flag.Var(diagramCommands, "diagram", "render code blocks of a language to svg with a command reading stdin,\n\tlike 'dot=dot -Tsvg', repeatable, overrides '-diagrams'")
 	flag.Usage = func() {
-		fmt.Println(usage)
+		fmt.Print(usage)
 		//fmt.Println("FLAGS")
 		flag.PrintDefaults()
 	}
 	rand.Seed(time.Now().UnixNano())
 }
 
-// Handler handles markdown requests
-type Handler struct {
-	Root           http.FileSystem // directory to serve
-	RootString     string          // keep directory name for comparing prefix
-	header, footer []byte          // for not-raw markdown requests
-}
-
 // markdown command
 func main() {
 	fmt.Println(sig)
 	flag.Parse()
-	serve(flag.Args())
+	command := serve
+	if flag.Arg(0) == "check" {
+		flag.CommandLine.Parse(flag.Args()[1:])
+		command = check
+	}
+	if *configFile != "" {
+		if err := loadConfig(flag.CommandLine, *configFile); err != nil {
+			println(err.Error())
+			os.Exit(111)
+		}
+	}
+	if *extensions == "help" {
+		presets, exts, flags := handler.ExtensionNames()
+		fmt.Println("presets:", strings.Join(presets, ", "))
+		fmt.Println("extensions:", strings.Join(exts, ", "))
+		fmt.Println("html flags:", strings.Join(flags, ", "))
+		return
+	}
+	command(flag.Args())
 }
 
 func serve(args []string) {
@@ -118,30 +345,77 @@ func serve(args []string) {
 
 	// get absolute path of flag.Arg(0)
 	dir := flag.Arg(0)
-	dir = prepareDirectory(dir)
 
-	if *indexPage != "gen" {
-		_, err := os.Stat(dir + *indexPage)
+	// serve a directory, or the contents of an archive
+	var files, archive fs.FS
+	if handler.IsArchive(dir) {
+		println("serving archive:", dir)
+		archive, dir = openArchive(dir)
+		files = archive
+	} else {
+		dir = prepareDirectory(dir)
+		files = handler.DirFS(dir)
+
+		// print absolute directory we are serving
+		println("serving filesystem:", dir)
+	}
+
+	// git reads the object store, outside of the sandbox
+	useGit := *gitRef != "" || *gitRefs || *gitInfo
+	if useGit && archive != nil {
+		println("can not use git with an archive")
+		os.Exit(111)
+	}
+	if useGit && *sandboxMode != "none" {
+		println("can not use git with '-sandbox'")
+		os.Exit(111)
+	}
+	if *gitRef != "" {
+		var err error
+		files, err = handler.GitFS(dir, *gitRef)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "warning: %q not found, did you forget '-index' flag?\n", *indexPage)
+			println(err.Error())
+			os.Exit(111)
 		}
+		println("serving git revision:", *gitRef)
 	}
 
-	// new markdown handler
-	mdhandler := &Handler{
-		Root:       http.Dir(dir),
-		RootString: dir,
+	if *indexPage != "gen" {
+		_, err := fs.Stat(files, *indexPage)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "warning: %q not found, did you forget '-index' flag?\n", *indexPage)
+		}
 	}
 
-	h := http.DefaultServeMux
-	h.Handle("/", mdhandler)
-	// print absolute directory we are serving
-	println("serving filesystem:", dir)
-
 	// take care of opening log file
 	openLogFile()
 	println("logging to:", *logfile)
 
+	// diagram commands run after the sandbox
+	if (*diagrams || len(diagramCommands) > 0) && *sandboxMode != "none" {
+		println("can not render diagrams with '-sandbox'")
+		os.Exit(111)
+	}
+
+	// markdown handler options, everything is read before the sandbox
+	opts := markdownOptions()
+	opts.Header = []byte("<!DOCTYPE html>\n")
+	opts.Syntax = *syntaxEnabled
+	opts.Raw = true
+	opts.LiveReload = *liveReload
+	opts.Backlinks = *backlinks
+	opts.BrokenLinks = *brokenLinks
+	opts.Watch = watchInterval()
+	opts.Server = serverheader
+	opts.Logger = logger
+	println("sanitize policy:", opts.Sanitizer.Name())
+	if opts.Diagrams != nil {
+		println("diagrams:", diagramFlag(opts.Diagrams).String())
+	}
+	if opts.Wiki != nil {
+		println("wiki links:", *wiki)
+	}
+
 	if *header != "" {
 		println("html header:", *header)
 		b, err := ioutil.ReadFile(*header)
@@ -149,9 +423,7 @@ func serve(args []string) {
 			println(err.Error())
 			os.Exit(111)
 		}
-		mdhandler.header = b
-	} else {
-		mdhandler.header = []byte("<!DOCTYPE html>\n")
+		opts.Header = b
 	}
 
 	if *footer != "" {
@@ -161,233 +433,176 @@ func serve(args []string) {
 			println(err.Error())
 			os.Exit(111)
 		}
-		mdhandler.footer = b
-	}
-
-	// create a http server
-	server := &http.Server{
-		Addr:              *addr,
-		Handler:           h,
-		ErrorLog:          logger,
-		MaxHeaderBytes:    (1 << 10), // 1KB
-		ReadTimeout:       (time.Second * 5),
-		WriteTimeout:      (time.Second * 5),
-		ReadHeaderTimeout: (time.Second * 5),
-		IdleTimeout:       (time.Second * 5),
-	}
-
-	// disable keepalives
-	server.SetKeepAlivesEnabled(false)
-
-	// trick to show listening port
-	go func() { <-time.After(time.Second); logger.Println("listening:", *addr) }()
-
-	// start serving
-	err := server.ListenAndServe()
-
-	// print usage info, probably started wrong or port is occupied
-	flag.Usage()
-
-	// always non-nil
-	logger.Println(err)
-
-	// any exit is an error
-	os.Exit(111)
-}
-
-// generate kind-of-unique string
-func rfid() string {
-	return fmt.Sprintf("request-%04X", rand.Intn(0xFFFF))
-}
-
-func (h Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	// all we want is GET
-	if r.Method != "GET" {
-		logger.Println("bad method:", r.RemoteAddr, r.Method, r.URL.Path, r.UserAgent())
-		http.NotFound(w, r)
-		return
+		opts.Footer = b
 	}
 
-	// deny requests containing '..'
-	if strings.Contains(r.URL.Path, "..") {
-		logger.Println("bad path:", r.RemoteAddr, r.Method, r.URL.Path, r.UserAgent())
-		http.NotFound(w, r)
-		return
+	// editors, for the browser editor, webdav and the broken links report
+	if *edit || *dav || *brokenLinks {
+		if *usersFile == "" {
+			println("'-edit', '-dav' and '-broken-links' need '-users'")
+			os.Exit(111)
+		}
+		users, err := handler.LoadUsers(*usersFile)
+		if err != nil {
+			println(err.Error())
+			os.Exit(111)
+		}
+		opts.Auth = users
+		println("editors:", *usersFile)
+	}
+	if *edit {
+		opts.Edit = true
+		opts.GitCommit = *editCommit
+		if *editCommit && !*gitInfo {
+			println("'-edit-commit' needs '-git'")
+			os.Exit(111)
+		}
+		println("editing enabled at: /name.md?edit")
 	}
-
-	// start timing
-	t1 := time.Now()
-
-	// Add Server header
-	w.Header().Add("Server", serverheader)
-
-	// Prevent page from being displayed in an iframe
-	w.Header().Add("X-Frame-Options", "DENY")
-
-	// generate unique request id
-	requestid := rfid()
-
-	// log how long this takes
-	defer func(t func() time.Time) {
-		logger.Println(requestid, "closed after", t().Sub(t1))
-	}(time.Now)
-
-	if *syntaxEnabled && r.URL.Path == "/gh.css" {
-		b, err := Asset("static/gh.css")
-		if err == nil {
-			w.Header().Add("Content-Type", "text/css")
-			w.Write(b)
-			return
+	if *dav {
+		if archive != nil || *gitRef != "" {
+			println("'-dav' needs a directory")
+			os.Exit(111)
 		}
+		opts.DAV = true
+		println("webdav enabled at: " + handler.CleanBasePath(*basePath) + "/_dav/")
 	}
 
-	// abs is not absolute yet
-	abs := r.URL.Path[1:] // remove slash prefix
-	if abs == "" && *indexPage != "gen" {
-		abs = *indexPage
+	trusted, err := parseTrustedProxies(*proxies)
+	if err != nil {
+		println(err.Error())
+		os.Exit(111)
 	}
 
-	// '/' suffix, add *index.Page
-	if *indexPage != "gen" && strings.HasSuffix(abs, "/") {
-		abs += *indexPage
+	mode, err := parseSocketMode(*socketMode)
+	if err != nil {
+		println(err.Error())
+		os.Exit(111)
 	}
 
-	// still not absolute, prepend root directory to filesrc
-	abs = h.RootString + abs
-
-	if *indexPage == "gen" && strings.HasSuffix(r.URL.Path, "/") {
-		logger.Println(requestid, "generated index:", abs)
-		http.ServeFile(w, r, abs)
-		return
+	// lookup user and group before the sandbox hides /etc/passwd
+	uid, gid, err := lookupIDs(*runUser, *runGroup)
+	if err != nil {
+		println(err.Error())
+		os.Exit(111)
 	}
 
-	// log now that we have filename
-	logger.Println(requestid, r.RemoteAddr, r.Method, r.URL.Path, "->", abs)
-
-	// get absolute path of requested file (could not exist)
-	abs, err := filepath.Abs(abs)
+	ln, err := listen(*addr, mode)
 	if err != nil {
-		logger.Println(requestid, "error resolving absolute path:", err)
-		http.NotFound(w, r)
-		return
+		logger.Println(err)
+		os.Exit(111)
 	}
+	logger.Println("listening:", ln.Addr())
 
-	// .html suffix, but .md exists. choose to serve .md over .html
-	if strings.HasSuffix(abs, ".html") {
-		trymd := strings.TrimSuffix(abs, ".html") + ".md"
-		_, err := os.Open(trymd)
-		if err == nil {
-			logger.Println(requestid, abs, "->", trymd)
-			abs = trymd
+	// let the dropped user own its unix socket
+	if ln.Addr().Network() == "unix" && (uid != -1 || gid != -1) {
+		if err := os.Chown(ln.Addr().String(), uid, gid); err != nil {
+			logger.Println(err)
+			os.Exit(111)
 		}
 	}
 
-	// check if exists, or give 404
-	_, err = os.Open(abs)
+	// listener is bound, give up filesystem access and privileges
+	dir, err = sandbox(*sandboxMode, dir, *edit || *dav)
 	if err != nil {
-		if strings.Contains(err.Error(), "no such file") {
-			logger.Println(requestid, "404", abs)
-			http.NotFound(w, r)
-			return
-		}
-
-		// probably permissions
-		logger.Println(requestid, "error opening file:", err, abs)
-		http.NotFound(w, r)
-		return
+		logger.Println(err)
+		os.Exit(111)
 	}
-
-	// check if symlink ( to avoid /proc/self/root style attacks )
-	if !fileisgood(abs) {
-		logger.Printf("%s error: %q is symlink. serving 404", requestid, abs)
-		http.NotFound(w, r)
-		return
+	if err := dropPrivileges(uid, gid); err != nil {
+		logger.Println(err)
+		os.Exit(111)
 	}
-
-	// compare prefix (alternate way of checking symlink?)
-	// above, we checked for abs vs symlink resolved,
-	// here lets check if they have the special prefix of "s.Root"
-	// probably redundant.
-	if !strings.HasPrefix(abs, h.RootString) {
-		logger.Println(requestid, "bad path", abs, "doesnt have prefix:", h.RootString)
-		http.NotFound(w, r)
-		return
+	if *sandboxMode != "none" {
+		logger.Println("sandbox:", *sandboxMode)
 	}
-
-	// read bytes (for detecting content type )
-	b, err := ioutil.ReadFile(abs)
-	if err != nil {
-		logger.Printf("%s error reading file: %q", requestid, abs)
-		http.NotFound(w, r)
-		return
+	if uid != -1 || gid != -1 {
+		logger.Printf("running as uid=%d gid=%d", os.Getuid(), os.Getgid())
 	}
 
-	// detect content type and encoding
-	ct := http.DetectContentType(b)
-
-	// serve raw html if exists
-	if strings.HasSuffix(abs, ".html") && strings.HasPrefix(ct, "text/html") {
-		logger.Println(requestid, "serving raw html:", abs)
-		w.Header().Add("Content-Type", "text/html")
-		w.Write(b)
-		return
+	// new markdown handler
+	opts.Root = archive
+	if opts.Root == nil {
+		opts.Root = handler.DirFS(dir)
+	}
+	if *gitRef != "" {
+		opts.Root = files
+	}
+	if *gitRefs {
+		opts.Refs = handler.GitRefs(dir)
+	}
+	if *gitInfo {
+		opts.Git = dir
+	}
+	mdhandler, err := handler.New(opts)
+	if err != nil {
+		logger.Println(err)
+		os.Exit(111)
 	}
 
-	// probably markdown
-	if strings.HasSuffix(abs, ".md") && strings.HasPrefix(ct, "text/plain") {
-		if strings.Contains(r.URL.RawQuery, "raw") {
-			logger.Println(requestid, "raw markdown request:", abs)
-			w.Write(b)
-			return
+	h := http.DefaultServeMux
+	base := handler.CleanBasePath(*basePath)
+	if base == "" {
+		h.Handle("/", mdhandler)
+	} else {
+		println("base path:", base+"/")
+		h.Handle(base+"/", http.StripPrefix(base, mdhandler))
+		h.Handle(base, http.RedirectHandler(base+"/", http.StatusMovedPermanently))
+	}
+
+	// rate limit and cap concurrent requests
+	var root http.Handler = h
+	if *rateMarkdown > 0 || *rateStatic > 0 || *maxRequests > 0 {
+		l := &limiter{
+			markdown: newBuckets(*rateMarkdown, *rateBurst),
+			static:   newBuckets(*rateStatic, *rateBurst),
+			next:     h,
 		}
-		logger.Println(requestid, "serving markdown:", abs)
-
-		md := markdown2html(b)
-		if md == nil {
-			w.WriteHeader(200)
-			return
+		if *liveReload {
+			l.stream = base + "/_reload"
 		}
-		w.Header().Add("Content-Type", "text/html")
-		w.Write(h.header)
-		w.Write(md)
-		w.Write(h.footer)
-		return
+		if *maxRequests > 0 {
+			l.sem = make(chan struct{}, *maxRequests)
+		}
+		root = l
 	}
 
-	// fallthrough with http.ServeFile
-	logger.Printf("%s serving %s file: %s", requestid, ct, abs)
-
-	http.ServeFile(w, r, abs)
-}
-
-// fileisgood returns false if symlink
-// comparing absolute vs resolved path is apparently quick and effective
-func fileisgood(abs string) bool {
-
-	// sanity check
-	if abs == "" {
-		return false
+	// find client address behind trusted reverse proxies
+	if !trusted.empty() {
+		root = realIP{proxies: trusted, next: root}
 	}
 
-	// is absolute really absolute?
-	var err error
-	if !filepath.IsAbs(abs) {
-		abs, err = filepath.Abs(abs)
-	}
-	if err != nil {
-		println(err.Error())
-		return false
+	// live reload streams stay open
+	if *liveReload {
+		root = stream{path: base + "/_reload", next: root}
 	}
 
-	// get real path after eval symlinks
-	realpath, err := filepath.EvalSymlinks(abs)
-	if err != nil {
-		println(err.Error())
-		return false
+	// create a http server
+	server := &http.Server{
+		Addr:              *addr,
+		Handler:           root,
+		ErrorLog:          logger,
+		MaxHeaderBytes:    (1 << 10), // 1KB
+		ReadTimeout:       (time.Second * 5),
+		WriteTimeout:      (time.Second * 5),
+		ReadHeaderTimeout: (time.Second * 5),
+		IdleTimeout:       (time.Second * 5),
+		ConnContext:       withConn,
 	}
 
-	// equality check
-	return realpath == abs
+	// disable keepalives
+	server.SetKeepAlivesEnabled(false)
+
+	// start serving
+	err = server.Serve(ln)
+
+	// print usage info, probably started wrong or port is occupied
+	flag.Usage()
+
+	// always non-nil
+	logger.Println(err)
+
+	// any exit is an error
+	os.Exit(111)
 }
 
 // prepare root filesystem directory for serving
@@ -414,27 +629,30 @@ func prepareDirectory(dir string) string {
 	return dir
 }
 
-func markdown2html(in []byte) []byte {
-	if len(in) == 0 {
-		return nil
+// open a zip, tar, or tar.gz archive to serve, and return its directory
+func openArchive(name string) (fs.FS, string) {
+	abs, err := filepath.Abs(name)
+	if err != nil {
+		println(err.Error())
+		os.Exit(111)
 	}
-	if *plain {
-		// default flags
-		flags := 0
-		if *toc {
-			flags |= blackfriday.HTML_TOC
-		}
-		md := blackfriday.Markdown(
-			in, blackfriday.HtmlRenderer(
-				// html flags
-				flags,
-				"", ""),
-			// extensions
-			0)
-		return md
+	f, err := os.Open(abs)
+	if err != nil {
+		println(err.Error())
+		os.Exit(111)
 	}
-
-	return github_flavored_markdown.Markdown(in)
+	stat, err := f.Stat()
+	if err != nil {
+		println(err.Error())
+		os.Exit(111)
+	}
+	// f stays open for serving zip files
+	fsys, err := handler.ArchiveFS(abs, f, stat.Size())
+	if err != nil {
+		println(err.Error())
+		os.Exit(111)
+	}
+	return fsys, filepath.Dir(abs)
 }
 
 // use logfile flag and set logger Logger
@@ -461,12 +679,3 @@ func openLogFile() {
 	}
 
 }
-
-func highlightSyntaxHTML(in []byte) (out []byte) {
-	out, err := syntaxhighlight.AsHTML(in)
-	if err != nil {
-		logger.Println("error highlighting syntax:", err)
-		return in
-	}
-	return out
-}
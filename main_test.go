@@ -1,12 +1,15 @@
 package main
 
 import (
+	"flag"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestPrepareDirectory(t *testing.T) {
@@ -25,118 +28,215 @@ func TestPrepareDirectory(t *testing.T) {
 	}
 }
 
-func TestFileIsGood(t *testing.T) {
-	dir := prepareDirectory("docs")
-	req := dir + "index.md" // index.md exists
-	if !fileisgood(req) {
-		// docs is a normal directory, not a symlink. should never be false
-		t.Logf("%s should be good, got bad", req)
-		t.FailNow()
+func TestClientIP(t *testing.T) {
+	trusted, err := parseTrustedProxies("127.0.0.1, 10.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		remote, xff, want string
+	}{
+		{"192.0.2.1:1234", "198.51.100.7", "192.0.2.1"},              // untrusted peer, ignore header
+		{"127.0.0.1:1234", "198.51.100.7", "198.51.100.7"},           // trusted proxy
+		{"127.0.0.1:1234", "198.51.100.7, 10.1.2.3", "198.51.100.7"}, // chain of trusted proxies
+		{"127.0.0.1:1234", "6.6.6.6, 198.51.100.7", "198.51.100.7"},  // spoofed left-most entry
+		{"127.0.0.1:1234", "", "127.0.0.1"},
+		{"127.0.0.1:1234", `for=198.51.100.7;proto=https`, "198.51.100.7"},
+		{"127.0.0.1:1234", `for="[2001:db8::1]:4711", for=10.0.0.1`, "2001:db8::1"},
+		{"127.0.0.1:1234", `for=_hidden`, "127.0.0.1"},
+	} {
+		req, _ := http.NewRequest("GET", "/", nil)
+		req.RemoteAddr = tc.remote
+		if strings.Contains(tc.xff, "for=") {
+			req.Header.Set("Forwarded", tc.xff)
+		} else if tc.xff != "" {
+			req.Header.Set("X-Forwarded-For", tc.xff)
+		}
+		if got := trusted.clientIP(req); got != tc.want {
+			t.Errorf("%s %q: expected %q, got %q", tc.remote, tc.xff, tc.want, got)
+		}
 	}
 }
 
-func TestRefuseSymlinks(t *testing.T) {
-	dir := prepareDirectory("docs")
-	os.Remove(dir + "index.link")
-	err := os.Symlink(dir+"index.md", dir+"index.link")
-	defer os.Remove(dir + "index.link")
+func TestClientProto(t *testing.T) {
+	trusted, err := parseTrustedProxies("127.0.0.1")
 	if err != nil {
-		t.Log("Error creating symlink:", err)
-		t.FailNow()
+		t.Fatal(err)
 	}
-	req := dir + "index.link"
-	if fileisgood(req) {
-		// index.link is a symlink. should never be true
-		t.Logf("%s should be good, got bad", req)
-		t.FailNow()
+	for _, tc := range []struct {
+		remote, forwarded, xfp, want string
+	}{
+		{"192.0.2.1:1234", "", "https", ""}, // untrusted peer, ignore header
+		{"127.0.0.1:1234", "", "https", "https"},
+		{"127.0.0.1:1234", "", "https, HTTP", "http"}, // set by the nearest proxy
+		{"127.0.0.1:1234", `for=198.51.100.7;proto=https`, "http", "https"},
+		{"127.0.0.1:1234", `for=198.51.100.7;proto="gopher"`, "", ""},
+		{"127.0.0.1:1234", "", "", ""},
+	} {
+		req, _ := http.NewRequest("GET", "/", nil)
+		req.RemoteAddr = tc.remote
+		if tc.forwarded != "" {
+			req.Header.Set("Forwarded", tc.forwarded)
+		}
+		if tc.xfp != "" {
+			req.Header.Set("X-Forwarded-Proto", tc.xfp)
+		}
+		if got := trusted.clientProto(req); got != tc.want {
+			t.Errorf("%s %q %q: expected %q, got %q", tc.remote, tc.forwarded, tc.xfp, tc.want, got)
+		}
 	}
 
+	// only requests from trusted proxies get the host and scheme of the client in their url
+	var got string
+	h := realIP{proxies: trusted, next: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.URL.Scheme + "://" + r.URL.Host
+	})}
+	for remote, want := range map[string]string{"127.0.0.1:1234": "https://example.com", "192.0.2.1:1234": "://"} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = remote
+		req.Header.Set("X-Forwarded-Proto", "https")
+		h.ServeHTTP(httptest.NewRecorder(), req)
+		if got != want {
+			t.Errorf("%s: expected %q, got %q", remote, want, got)
+		}
+	}
 }
 
-func TestRefuseDotDots(t *testing.T) {
-	dir := prepareDirectory("docs")
-	req, _ := http.NewRequest("GET", "/../main.go", nil)
-	w := httptest.NewRecorder()
-	h := &Handler{
-		Root:       http.Dir(dir),
-		RootString: dir,
+func TestRateLimit(t *testing.T) {
+	l := &limiter{
+		markdown: newBuckets(1, 2),
+		next:     http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
 	}
-	h.ServeHTTP(w, req)
-	resp := w.Result()
-	body, _ := ioutil.ReadAll(resp.Body)
-
-	if resp.StatusCode != http.StatusNotFound {
-		t.Log("Expected 404, got:", resp.StatusCode)
-		t.FailNow()
+	codes := []int{}
+	for i := 0; i < 3; i++ {
+		req, _ := http.NewRequest("GET", "/index.md", nil)
+		req.RemoteAddr = "192.0.2.1:1234"
+		w := httptest.NewRecorder()
+		l.ServeHTTP(w, req)
+		codes = append(codes, w.Code)
+		if w.Code == http.StatusTooManyRequests && w.Header().Get("Retry-After") == "" {
+			t.Error("expected Retry-After header")
+		}
 	}
-
-	if len(body) != 19 {
-		t.Log("Expected 404 body, got:", len(body), string(body))
-		t.FailNow()
+	if codes[0] != 200 || codes[1] != 200 || codes[2] != http.StatusTooManyRequests {
+		t.Errorf("expected 200, 200, 429, got %v", codes)
 	}
 
-	if string(body) != "404 page not found\n" {
-		t.Logf("Expected %q, got: %q", "404 page not found\n", string(body))
-		t.FailNow()
+	// static files have their own (unlimited) budget
+	req, _ := http.NewRequest("GET", "/markdownd.png", nil)
+	req.RemoteAddr = "192.0.2.1:1234"
+	w := httptest.NewRecorder()
+	l.ServeHTTP(w, req)
+	if w.Code != 200 {
+		t.Errorf("expected 200 for static file, got %d", w.Code)
 	}
 }
 
-func TestHTMLHeaderFooter(t *testing.T) {
-	dir := prepareDirectory("docs")
-	req, _ := http.NewRequest("GET", "/", nil)
-	w := httptest.NewRecorder()
-	h := &Handler{
-		Root:       http.Dir(dir),
-		RootString: dir,
-		header:     []byte("001"),
-		footer:     []byte("002"),
-	}
-	h.ServeHTTP(w, req)
-	resp := w.Result()
-	body, _ := ioutil.ReadAll(resp.Body)
-
-	if resp.StatusCode != 200 {
-		t.Log("Expected 200, got:", resp.StatusCode)
-		t.FailNow()
+func TestListenUnix(t *testing.T) {
+	dir, err := ioutil.TempDir("", "markdownd")
+	if err != nil {
+		t.Fatal(err)
 	}
-
-	bodystr := string(body)
-
-	if !strings.HasPrefix(bodystr, "001") {
-		t.Log("Expected prefix of '001'")
-		t.Fail()
+	defer os.RemoveAll(dir)
+	sock := dir + "/markdownd.sock"
+
+	// twice, to check the stale socket is removed
+	for i := 0; i < 2; i++ {
+		l, err := listen("unix:"+sock, 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fi, err := os.Stat(sock)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi.Mode().Perm() != 0600 {
+			t.Errorf("Expected socket mode 0600, got %v", fi.Mode().Perm())
+		}
+		if i == 0 {
+			// leave a stale socket behind, like a crash would
+			l.(*net.UnixListener).SetUnlinkOnClose(false)
+		}
+		l.Close()
 	}
 
-	if !strings.HasSuffix(bodystr, "002") {
-		t.Log("Expected suffix of '002'")
-		t.Fail()
+	// never remove regular files
+	ioutil.WriteFile(sock, []byte("hello"), 0600)
+	if _, err := listen("unix:"+sock, 0600); err == nil {
+		t.Error("Expected error listening on top of a regular file")
 	}
 }
 
-func sendRequest(req *http.Request) *http.Response {
-	dir := prepareDirectory("docs")
-	w := httptest.NewRecorder()
-	h := &Handler{
-		Root:       http.Dir(dir),
-		RootString: dir,
+func TestLoadConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "markdownd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	config := dir + "/markdownd.yaml"
+	ioutil.WriteFile(config, []byte("toc: true\nindex: README.md\ntoc-depth: 2\nextensions: commonmark,tables\ndiagram:\n  - dot=dot -Tsvg\n  - pic=pic2svg\nsite:\n  Version: 1.2\n  name: Docs\n"), 0600)
+
+	fs := flag.NewFlagSet("markdownd", flag.ContinueOnError)
+	toc := fs.Bool("toc", false, "")
+	index := fs.String("index", "index.md", "")
+	depth := fs.Int("toc-depth", 3, "")
+	exts := fs.String("extensions", "", "")
+	diagrams := diagramFlag{}
+	fs.Var(diagrams, "diagram", "")
+	site := siteFlag{}
+	fs.Var(site, "site", "")
+	if err := fs.Parse([]string{"-index", "HOME.md"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadConfig(fs, config); err != nil {
+		t.Fatal(err)
+	}
+	if !*toc || *index != "HOME.md" || *depth != 2 || *exts != "commonmark,tables" {
+		t.Errorf("unexpected flags: toc=%v index=%q toc-depth=%d extensions=%q", *toc, *index, *depth, *exts)
+	}
+	if diagrams.String() != "dot=dot -Tsvg, pic=pic2svg" {
+		t.Errorf("unexpected diagrams: %q", diagrams)
+	}
+	if site.String() != "Version=1.2, name=Docs" {
+		t.Errorf("unexpected site variables: %q", site)
 	}
-	h.ServeHTTP(w, req)
-	resp := w.Result()
-
-	return resp
 
+	// unknown keys and bad values
+	for _, bad := range []string{"tco: true\n", "toc-depth: deep\n", "- toc\n"} {
+		ioutil.WriteFile(config, []byte(bad), 0600)
+		fs := flag.NewFlagSet("markdownd", flag.ContinueOnError)
+		fs.Bool("toc", false, "")
+		fs.Int("toc-depth", 3, "")
+		if err := loadConfig(fs, config); err == nil {
+			t.Errorf("expected error for %q", bad)
+		}
+	}
 }
 
-func TestBadMethods(t *testing.T) {
-	methods := []string{"POST", "PUT", "DELETE", "HEAD",
-		"OPTIONS", "TRACE", "CONNECT", "DUMMY"}
-	for _, method := range methods {
-		req, _ := http.NewRequest(method, "/", nil)
-		resp := sendRequest(req)
-		if resp.StatusCode != 404 {
-			t.Log("Expected 404, got:", resp.StatusCode)
-			t.FailNow()
+func TestStreamWriteTimeout(t *testing.T) {
+	slow := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(300 * time.Millisecond)
+		w.Write([]byte("late"))
+	})
+	s := httptest.NewUnstartedServer(stream{path: "/_reload", next: slow})
+	s.Config.WriteTimeout = 100 * time.Millisecond
+	s.Config.ConnContext = withConn
+	s.Start()
+	defer s.Close()
+
+	get := func(path string) (string, error) {
+		resp, err := http.Get(s.URL + path)
+		if err != nil {
+			return "", err
 		}
-
+		defer resp.Body.Close()
+		b, err := ioutil.ReadAll(resp.Body)
+		return string(b), err
+	}
+	if body, err := get("/_reload"); err != nil || body != "late" {
+		t.Errorf("stream: expected %q, got %q %v", "late", body, err)
+	}
+	if body, err := get("/page.md"); err == nil {
+		t.Errorf("expected the write timeout for other requests, got %q", body)
 	}
 }
@@ -0,0 +1,64 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"fmt"
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+// lookupIDs returns the uid and gid for username and groupname.
+// without groupname, the primary group of the user is used.
+func lookupIDs(username, groupname string) (uid, gid int, err error) {
+	uid, gid = -1, -1
+	if username != "" {
+		u, err := user.Lookup(username)
+		if err != nil {
+			return -1, -1, err
+		}
+		if uid, err = strconv.Atoi(u.Uid); err != nil {
+			return -1, -1, fmt.Errorf("bad uid for %q: %v", username, err)
+		}
+		if gid, err = strconv.Atoi(u.Gid); err != nil {
+			return -1, -1, fmt.Errorf("bad gid for %q: %v", username, err)
+		}
+	}
+	if groupname != "" {
+		g, err := user.LookupGroup(groupname)
+		if err != nil {
+			return -1, -1, err
+		}
+		if gid, err = strconv.Atoi(g.Gid); err != nil {
+			return -1, -1, fmt.Errorf("bad gid for %q: %v", groupname, err)
+		}
+	}
+	return uid, gid, nil
+}
+
+// dropPrivileges switches to gid and uid, ignoring -1
+func dropPrivileges(uid, gid int) error {
+	if gid != -1 {
+		if err := syscall.Setgroups([]int{gid}); err != nil {
+			return fmt.Errorf("setgroups: %v", err)
+		}
+		if err := syscall.Setgid(gid); err != nil {
+			return fmt.Errorf("setgid: %v", err)
+		}
+	}
+	if uid != -1 {
+		if err := syscall.Setuid(uid); err != nil {
+			return fmt.Errorf("setuid: %v", err)
+		}
+	}
+	return nil
+}
+
+func chroot(dir string) error {
+	if err := syscall.Chroot(dir); err != nil {
+		return err
+	}
+	return syscall.Chdir("/")
+}
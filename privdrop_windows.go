@@ -0,0 +1,23 @@
+package main
+
+import "errors"
+
+var errNotSupported = errors.New("not supported on windows")
+
+func lookupIDs(username, groupname string) (uid, gid int, err error) {
+	if username != "" || groupname != "" {
+		return -1, -1, errNotSupported
+	}
+	return -1, -1, nil
+}
+
+func dropPrivileges(uid, gid int) error {
+	if uid != -1 || gid != -1 {
+		return errNotSupported
+	}
+	return nil
+}
+
+func chroot(dir string) error {
+	return errNotSupported
+}
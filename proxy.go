@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// trustedProxies are the peers allowed to set Forwarded and X-Forwarded-For
+type trustedProxies struct {
+	nets []*net.IPNet
+	unix bool // trust peers connecting to a unix socket
+}
+
+// parseTrustedProxies parses a comma separated list of addresses or CIDR networks,
+// and the keyword "unix" for unix socket peers
+func parseTrustedProxies(s string) (trustedProxies, error) {
+	var t trustedProxies
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		if field == "unix" {
+			t.unix = true
+			continue
+		}
+		if !strings.Contains(field, "/") {
+			ip := net.ParseIP(field)
+			if ip == nil {
+				return t, fmt.Errorf("bad trusted proxy address: %q", field)
+			}
+			bits := 32
+			if ip.To4() == nil {
+				bits = 128
+			}
+			field = fmt.Sprintf("%s/%d", ip, bits)
+		}
+		_, ipnet, err := net.ParseCIDR(field)
+		if err != nil {
+			return t, fmt.Errorf("bad trusted proxy network: %q", field)
+		}
+		t.nets = append(t.nets, ipnet)
+	}
+	return t, nil
+}
+
+// empty returns true if no proxy is trusted
+func (t trustedProxies) empty() bool {
+	return len(t.nets) == 0 && !t.unix
+}
+
+// contains returns true if ip is one of the trusted proxies
+func (t trustedProxies) contains(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, ipnet := range t.nets {
+		if ipnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// trusts returns true if the request peer is a trusted proxy
+func (t trustedProxies) trusts(host string) bool {
+	ip := net.ParseIP(host)
+	if ip == nil {
+		// unix socket peers have no address
+		return t.unix
+	}
+	return t.contains(ip)
+}
+
+// clientIP returns the address of the client making the request.
+// Forwarded (or else X-Forwarded-For) is only used when the request comes from
+// a trusted proxy, and is read right to left, skipping other trusted proxies.
+func (t trustedProxies) clientIP(r *http.Request) string {
+	host := remoteHost(r)
+	if t.empty() || !t.trusts(host) {
+		return host
+	}
+	hops := forwardedFor(r.Header["Forwarded"])
+	if len(hops) == 0 {
+		hops = strings.Split(strings.Join(r.Header["X-Forwarded-For"], ","), ",")
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := net.ParseIP(strings.TrimSpace(hops[i]))
+		if ip == nil {
+			// garbage or obfuscated, stop trusting the chain here
+			break
+		}
+		host = ip.String()
+		if !t.contains(ip) {
+			break
+		}
+	}
+	return host
+}
+
+// clientProto returns the scheme the client used, "http" or "https", as set by a trusted proxy
+// in Forwarded (or else X-Forwarded-Proto), or "" when the request does not come from one.
+// the last value is the one the proxy nearest to us set.
+func (t trustedProxies) clientProto(r *http.Request) string {
+	if t.empty() || !t.trusts(remoteHost(r)) {
+		return ""
+	}
+	protos := forwardedParam(r.Header["Forwarded"], "proto")
+	if len(protos) == 0 {
+		protos = strings.Split(strings.Join(r.Header["X-Forwarded-Proto"], ","), ",")
+	}
+	switch proto := strings.ToLower(strings.TrimSpace(protos[len(protos)-1])); proto {
+	case "http", "https":
+		return proto
+	}
+	return ""
+}
+
+// forwardedFor returns the 'for' addresses of RFC 7239 Forwarded headers
+func forwardedFor(headers []string) []string {
+	var hops []string
+	for _, node := range forwardedParam(headers, "for") {
+		// [2001:db8::1]:4711 or 192.0.2.1:4711
+		if host, _, err := net.SplitHostPort(node); err == nil {
+			node = host
+		}
+		hops = append(hops, strings.Trim(node, "[]"))
+	}
+	return hops
+}
+
+// forwardedParam returns the unquoted values of a parameter, like 'for', in RFC 7239 Forwarded headers
+func forwardedParam(headers []string, name string) []string {
+	var values []string
+	for _, header := range headers {
+		for _, element := range strings.Split(header, ",") {
+			for _, pair := range strings.Split(element, ";") {
+				pair = strings.TrimSpace(pair)
+				if len(pair) <= len(name) || pair[len(name)] != '=' || !strings.EqualFold(pair[:len(name)], name) {
+					continue
+				}
+				values = append(values, strings.Trim(pair[len(name)+1:], `"`))
+			}
+		}
+	}
+	return values
+}
+
+// remoteHost returns the request remote address without port
+func remoteHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// realIP replaces the request remote address with the client address,
+// so logs and rate limits see the client instead of the proxy.
+// requests from a trusted proxy get the host and scheme the client used in their url,
+// for absolute urls like in feeds.
+type realIP struct {
+	proxies trustedProxies
+	next    http.Handler
+}
+
+func (h realIP) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.proxies.trusts(remoteHost(r)) {
+		r.URL.Host = r.Host
+		r.URL.Scheme = h.proxies.clientProto(r)
+	}
+	r.RemoteAddr = h.proxies.clientIP(r)
+	h.next.ServeHTTP(w, r)
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// bucket is a token bucket for one client
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// buckets holds one token bucket per client address
+type buckets struct {
+	rate    float64 // tokens per second
+	burst   float64 // bucket size
+	mu      sync.Mutex
+	clients map[string]*bucket
+	pruned  time.Time
+}
+
+func newBuckets(rate float64, burst int) *buckets {
+	if burst < 1 {
+		burst = 1
+	}
+	return &buckets{
+		rate:    rate,
+		burst:   float64(burst),
+		clients: make(map[string]*bucket),
+	}
+}
+
+// take a token for client, or return how long to wait for one
+func (b *buckets) take(client string, now time.Time) (ok bool, wait time.Duration) {
+	if b == nil || b.rate <= 0 {
+		return true, 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.prune(now)
+
+	c, found := b.clients[client]
+	if !found {
+		c = &bucket{tokens: b.burst, last: now}
+		b.clients[client] = c
+	}
+
+	// refill
+	c.tokens = math.Min(b.burst, c.tokens+now.Sub(c.last).Seconds()*b.rate)
+	c.last = now
+
+	if c.tokens >= 1 {
+		c.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - c.tokens) / b.rate * float64(time.Second))
+}
+
+// prune forgets clients whose bucket would be full again, once a minute
+func (b *buckets) prune(now time.Time) {
+	if now.Sub(b.pruned) < time.Minute {
+		return
+	}
+	b.pruned = now
+	full := time.Duration(b.burst / b.rate * float64(time.Second))
+	for client, c := range b.clients {
+		if now.Sub(c.last) > full {
+			delete(b.clients, client)
+		}
+	}
+}
+
+// limiter rate limits requests per client, and caps concurrent requests
+type limiter struct {
+	markdown *buckets      // budget for rendered pages
+	static   *buckets      // budget for everything else
+	sem      chan struct{} // nil for no concurrency cap
+	stream   string        // live reload path, not counted in the cap
+	next     http.Handler
+}
+
+func (l *limiter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	client := remoteHost(r)
+
+	b := l.static
+	if isRendered(r.URL.Path) {
+		b = l.markdown
+	}
+	if ok, wait := b.take(client, time.Now()); !ok {
+		logger.Println("rate limited:", client, r.Method, r.URL.Path, r.UserAgent())
+		tooManyRequests(w, wait)
+		return
+	}
+
+	// live reload streams stay open, and would fill the cap
+	if l.sem != nil && r.URL.Path != l.stream {
+		select {
+		case l.sem <- struct{}{}:
+			defer func() { <-l.sem }()
+		default:
+			logger.Println("too many concurrent requests:", client, r.Method, r.URL.Path)
+			tooManyRequests(w, time.Second)
+			return
+		}
+	}
+
+	l.next.ServeHTTP(w, r)
+}
+
+// isRendered guesses if a path will be rendered from markdown
+func isRendered(p string) bool {
+	switch path.Ext(p) {
+	case ".md", ".html", "":
+		return true
+	}
+	return false
+}
+
+func tooManyRequests(w http.ResponseWriter, wait time.Duration) {
+	seconds := int(math.Ceil(wait.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"fmt"
+)
+
+// sandbox restricts filesystem access to the served directory,
+// and returns the path to serve it from.
+// it is called after the listener is bound and before dropping privileges.
+// with write, files in dir can still be replaced.
+func sandbox(mode string, dir string, write bool) (string, error) {
+	switch mode {
+	case "", "none":
+		return dir, nil
+	case "chroot":
+		if err := chroot(dir); err != nil {
+			return "", fmt.Errorf("chroot: %v", err)
+		}
+		// served directory is now the root directory
+		return "/", nil
+	case "landlock":
+		if err := landlock(dir, write); err != nil {
+			return "", fmt.Errorf("landlock: %v", err)
+		}
+		return dir, nil
+	default:
+		return "", fmt.Errorf("unknown sandbox: %q, try 'chroot' or 'landlock'", mode)
+	}
+}